@@ -2,7 +2,11 @@ module github.com/smallcodebases/shopping
 
 go 1.26.0
 
-require modernc.org/sqlite v1.44.3
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/text v0.42.0
+	modernc.org/sqlite v1.44.3
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -14,90 +14,364 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"io"
 	"io/fs"
 	"log/slog"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 //go:embed migrations/*.sql
 var migrationFS embed.FS
 
-type queryKey int
+// Each query's key is its name, so that GET /api/debug/queries output can be compared from build to build.
+type queryKey string
 
 const (
-	queryKeyBumpDataVersion queryKey = iota
-	queryKeyDeleteItem
-	queryKeyDeleteSection
-	queryKeyDeleteStore
-	queryKeyExistsItemById
-	queryKeyExistsItemByName
-	queryKeyExistsSectionByStoreIdSectionId
-	queryKeyExistsStoreById
-	queryKeyExistsStoreByName
-	queryKeyGetDataVersion
-	queryKeyGetItemStores
-	queryKeyGetItems
-	queryKeyGetSectionIdsByStore
-	queryKeyGetSections
-	queryKeyGetStores
-	queryKeyInsertItem
-	queryKeyInsertSection
-	queryKeyInsertStore
-	queryKeyItemOffList
-	queryKeyItemOnList
-	queryKeyItemStoreHasSection
-	queryKeyUpdateItemName
-	queryKeyUpdateSectionName
-	queryKeyUpdateSectionPosition
-	queryKeyUpdateStoreName
-	queryKeyUpsertItemStore
+	queryKeyAutocompleteItems                    queryKey = "AutocompleteItems"
+	queryKeyBumpDataVersion                      queryKey = "BumpDataVersion"
+	queryKeyBumpDataVersionHighWater             queryKey = "BumpDataVersionHighWater"
+	queryKeyClearList                            queryKey = "ClearList"
+	queryKeyCopyItemStoresToStore                queryKey = "CopyItemStoresToStore"
+	queryKeyCountItemStoresWithMatchingSection   queryKey = "CountItemStoresWithMatchingSection"
+	queryKeyDeleteAllItems                       queryKey = "DeleteAllItems"
+	queryKeyDeleteAllStores                      queryKey = "DeleteAllStores"
+	queryKeyDeleteAllTemplates                   queryKey = "DeleteAllTemplates"
+	queryKeyDeleteAllTrips                       queryKey = "DeleteAllTrips"
+	queryKeyDeleteItem                           queryKey = "DeleteItem"
+	queryKeyDeleteSection                        queryKey = "DeleteSection"
+	queryKeyDeleteStore                          queryKey = "DeleteStore"
+	queryKeyEndTrip                              queryKey = "EndTrip"
+	queryKeyExistsItemById                       queryKey = "ExistsItemById"
+	queryKeyExistsItemByName                     queryKey = "ExistsItemByName"
+	queryKeyExistsOtherItemByName                queryKey = "ExistsOtherItemByName"
+	queryKeyExistsOtherStoreByName               queryKey = "ExistsOtherStoreByName"
+	queryKeyExistsSectionByStoreIdSectionId      queryKey = "ExistsSectionByStoreIdSectionId"
+	queryKeyExistsStoreById                      queryKey = "ExistsStoreById"
+	queryKeyExistsTemplateById                   queryKey = "ExistsTemplateById"
+	queryKeyExistsTemplateByName                 queryKey = "ExistsTemplateByName"
+	queryKeyGetActiveTrip                        queryKey = "GetActiveTrip"
+	queryKeyGetActiveTripId                      queryKey = "GetActiveTripId"
+	queryKeyGetChecksumItems                     queryKey = "GetChecksumItems"
+	queryKeyGetChecksumItemStores                queryKey = "GetChecksumItemStores"
+	queryKeyGetChecksumSections                  queryKey = "GetChecksumSections"
+	queryKeyGetChecksumStores                    queryKey = "GetChecksumStores"
+	queryKeyGetDataVersion                       queryKey = "GetDataVersion"
+	queryKeyGetDeletedItemsSince                 queryKey = "GetDeletedItemsSince"
+	queryKeyGetDeletedItemStoresSince            queryKey = "GetDeletedItemStoresSince"
+	queryKeyGetDeletedSectionsSince              queryKey = "GetDeletedSectionsSince"
+	queryKeyGetDeletedStoresSince                queryKey = "GetDeletedStoresSince"
+	queryKeyGetDuplicateItems                    queryKey = "GetDuplicateItems"
+	queryKeyGetEmptySectionIds                   queryKey = "GetEmptySectionIds"
+	queryKeyGetItem                              queryKey = "GetItem"
+	queryKeyGetItemIdByName                      queryKey = "GetItemIdByName"
+	queryKeyGetItemName                          queryKey = "GetItemName"
+	queryKeyGetItemNames                         queryKey = "GetItemNames"
+	queryKeyGetItemOnList                        queryKey = "GetItemOnList"
+	queryKeyGetItemStores                        queryKey = "GetItemStores"
+	queryKeyGetItems                             queryKey = "GetItems"
+	queryKeyGetItemsChangedSince                 queryKey = "GetItemsChangedSince"
+	queryKeyGetItemsFiltered                     queryKey = "GetItemsFiltered"
+	queryKeyGetItemsSince                        queryKey = "GetItemsSince"
+	queryKeyGetItemStoreCounts                   queryKey = "GetItemStoreCounts"
+	queryKeyGetItemStoresByItem                  queryKey = "GetItemStoresByItem"
+	queryKeyGetItemStoresBySection               queryKey = "GetItemStoresBySection"
+	queryKeyGetItemStoresByStore                 queryKey = "GetItemStoresByStore"
+	queryKeyGetItemStoresSince                   queryKey = "GetItemStoresSince"
+	queryKeyGetLayoutSections                    queryKey = "GetLayoutSections"
+	queryKeyGetLayoutStores                      queryKey = "GetLayoutStores"
+	queryKeyGetListItemsNotSoldAtStore           queryKey = "GetListItemsNotSoldAtStore"
+	queryKeyGetLowStockItems                     queryKey = "GetLowStockItems"
+	queryKeyGetNeededItems                       queryKey = "GetNeededItems"
+	queryKeyGetOnListItems                       queryKey = "GetOnListItems"
+	queryKeyGetOnListItemStores                  queryKey = "GetOnListItemStores"
+	queryKeyGetOrphanListItems                   queryKey = "GetOrphanListItems"
+	queryKeyGetRecentItems                       queryKey = "GetRecentItems"
+	queryKeyGetSection                           queryKey = "GetSection"
+	queryKeyGetSectionIdByNameCaseInsensitive    queryKey = "GetSectionIdByNameCaseInsensitive"
+	queryKeyGetSectionIdsByStore                 queryKey = "GetSectionIdsByStore"
+	queryKeyGetSectionItemsByNameCaseInsensitive queryKey = "GetSectionItemsByNameCaseInsensitive"
+	queryKeyGetSectionName                       queryKey = "GetSectionName"
+	queryKeyGetSectionPositionsByStore           queryKey = "GetSectionPositionsByStore"
+	queryKeyGetSectionPositionsForRepair         queryKey = "GetSectionPositionsForRepair"
+	queryKeyGetSections                          queryKey = "GetSections"
+	queryKeyGetSectionsByNameCaseInsensitive     queryKey = "GetSectionsByNameCaseInsensitive"
+	queryKeyGetSectionsByStore                   queryKey = "GetSectionsByStore"
+	queryKeyGetSectionsSince                     queryKey = "GetSectionsSince"
+	queryKeyGetSectionStore                      queryKey = "GetSectionStore"
+	queryKeyGetStore                             queryKey = "GetStore"
+	queryKeyGetStoreCompleteness                 queryKey = "GetStoreCompleteness"
+	queryKeyGetStoreIdByName                     queryKey = "GetStoreIdByName"
+	queryKeyGetStoreName                         queryKey = "GetStoreName"
+	queryKeyGetStores                            queryKey = "GetStores"
+	queryKeyGetStoresByRecent                    queryKey = "GetStoresByRecent"
+	queryKeyGetStoreSectionItemOrder             queryKey = "GetStoreSectionItemOrder"
+	queryKeyGetStoreSoldCounts                   queryKey = "GetStoreSoldCounts"
+	queryKeyGetStoresSince                       queryKey = "GetStoresSince"
+	queryKeyGetSyncFloor                         queryKey = "GetSyncFloor"
+	queryKeyGetTableCounts                       queryKey = "GetTableCounts"
+	queryKeyGetTemplateItems                     queryKey = "GetTemplateItems"
+	queryKeyGetTemplates                         queryKey = "GetTemplates"
+	queryKeyGetTotalChanges                      queryKey = "GetTotalChanges"
+	queryKeyGetTripItemIds                       queryKey = "GetTripItemIds"
+	queryKeyGetUnlinkedTemplateItemNames         queryKey = "GetUnlinkedTemplateItemNames"
+	queryKeyGetUnusedItems                       queryKey = "GetUnusedItems"
+	queryKeyImportItem                           queryKey = "ImportItem"
+	queryKeyImportItemStore                      queryKey = "ImportItemStore"
+	queryKeyImportSection                        queryKey = "ImportSection"
+	queryKeyImportStore                          queryKey = "ImportStore"
+	queryKeyInsertItem                           queryKey = "InsertItem"
+	queryKeyInsertPurchase                       queryKey = "InsertPurchase"
+	queryKeyInsertSection                        queryKey = "InsertSection"
+	queryKeyInsertSectionPurchases               queryKey = "InsertSectionPurchases"
+	queryKeyInsertStore                          queryKey = "InsertStore"
+	queryKeyInsertStorePurchases                 queryKey = "InsertStorePurchases"
+	queryKeyInsertTemplate                       queryKey = "InsertTemplate"
+	queryKeyInsertTemplateItemsFromList          queryKey = "InsertTemplateItemsFromList"
+	queryKeyInsertTrip                           queryKey = "InsertTrip"
+	queryKeyInsertTripItem                       queryKey = "InsertTripItem"
+	queryKeyInsertTripPurchases                  queryKey = "InsertTripPurchases"
+	queryKeyItemOffList                          queryKey = "ItemOffList"
+	queryKeyItemOnList                           queryKey = "ItemOnList"
+	queryKeyItemOnListWithDetails                queryKey = "ItemOnListWithDetails"
+	queryKeyItemStoreHasSection                  queryKey = "ItemStoreHasSection"
+	queryKeyLinkTemplateItem                     queryKey = "LinkTemplateItem"
+	queryKeyLinkTemplateItemsByName              queryKey = "LinkTemplateItemsByName"
+	queryKeyMoveItemStoresToSection              queryKey = "MoveItemStoresToSection"
+	queryKeyRaiseSyncFloor                       queryKey = "RaiseSyncFloor"
+	queryKeyRenameUnit                           queryKey = "RenameUnit"
+	queryKeySectionItemsOffList                  queryKey = "SectionItemsOffList"
+	queryKeySectionItemsOnList                   queryKey = "SectionItemsOnList"
+	queryKeyStoreItemsOffList                    queryKey = "StoreItemsOffList"
+	queryKeyTemplateItemsOnList                  queryKey = "TemplateItemsOnList"
+	queryKeyTripItemsOffList                     queryKey = "TripItemsOffList"
+	queryKeyUpdateItemHave                       queryKey = "UpdateItemHave"
+	queryKeyUpdateItemLowStock                   queryKey = "UpdateItemLowStock"
+	queryKeyUpdateItemName                       queryKey = "UpdateItemName"
+	queryKeyUpdateItemQuantity                   queryKey = "UpdateItemQuantity"
+	queryKeyUpdateItemStoreOrderIndex            queryKey = "UpdateItemStoreOrderIndex"
+	queryKeyUpdateItemStorePrice                 queryKey = "UpdateItemStorePrice"
+	queryKeyUpdateItemStoreSold                  queryKey = "UpdateItemStoreSold"
+	queryKeyUpdateItemUnit                       queryKey = "UpdateItemUnit"
+	queryKeyUpdateSectionAisle                   queryKey = "UpdateSectionAisle"
+	queryKeyUpdateSectionName                    queryKey = "UpdateSectionName"
+	queryKeyUpdateSectionPosition                queryKey = "UpdateSectionPosition"
+	queryKeyUpdateStoreMeta                      queryKey = "UpdateStoreMeta"
+	queryKeyUpdateStoreName                      queryKey = "UpdateStoreName"
+	queryKeyUpsertItemStore                      queryKey = "UpsertItemStore"
+	queryKeyUpsertItemStoreSold                  queryKey = "UpsertItemStoreSold"
 )
 
 var queries = map[queryKey]string{
-	queryKeyBumpDataVersion:                 "UPDATE data_version SET version = version + 1 RETURNING version",
-	queryKeyDeleteItem:                      "DELETE FROM items WHERE id = ?",
-	queryKeyDeleteSection:                   "DELETE FROM sections WHERE id = ?",
-	queryKeyDeleteStore:                     "DELETE FROM stores WHERE id = ?",
-	queryKeyExistsItemById:                  "SELECT EXISTS (SELECT 1 FROM items WHERE id = ?)",
-	queryKeyExistsItemByName:                "SELECT EXISTS (SELECT 1 FROM items WHERE name = ?)",
-	queryKeyExistsSectionByStoreIdSectionId: "SELECT EXISTS (SELECT 1 FROM sections WHERE store = ? AND id = ?)",
-	queryKeyExistsStoreById:                 "SELECT EXISTS (SELECT 1 FROM stores WHERE id = ?)",
-	queryKeyExistsStoreByName:               "SELECT EXISTS (SELECT 1 FROM stores WHERE name = ?)",
-	queryKeyGetDataVersion:                  "SELECT version FROM data_version",
-	queryKeyGetItemStores:                   "SELECT item, store, sold, section FROM item_stores",
-	queryKeyGetItems:                        "SELECT id, name, on_list FROM items",
-	queryKeyGetSectionIdsByStore:            "SELECT id FROM sections WHERE store = ? ORDER BY id",
-	queryKeyGetSections:                     "SELECT id, store, position, name FROM sections",
-	queryKeyGetStores:                       "SELECT id, name FROM stores",
-	queryKeyInsertItem:                      "INSERT INTO items (name, on_list) VALUES (?, ?) RETURNING id",
-	queryKeyInsertSection:                   "INSERT INTO sections (store, position, name) VALUES (?, COALESCE((SELECT MAX(position) + 1 FROM sections WHERE store = ?), 0), ?) RETURNING id, position",
-	queryKeyInsertStore:                     "INSERT INTO stores (name) VALUES (?) ON CONFLICT (name) DO NOTHING RETURNING id",
-	queryKeyItemOffList:                     "UPDATE items SET on_list = 0 WHERE id = ?",
-	queryKeyItemOnList:                      "UPDATE items SET on_list = 1 WHERE id = ?",
-	queryKeyItemStoreHasSection:             "SELECT EXISTS (SELECT 1 FROM item_stores WHERE item = ? AND store = ? AND section IS NOT NULL)",
-	queryKeyUpdateItemName:                  "UPDATE items SET name = ? WHERE id = ?",
-	queryKeyUpdateSectionName:               "UPDATE sections SET name = ? WHERE id = ?",
-	queryKeyUpdateSectionPosition:           "UPDATE sections SET position = ? WHERE id = ? AND store = ?",
-	queryKeyUpdateStoreName:                 "UPDATE stores SET name = ? WHERE id = ?",
-	queryKeyUpsertItemStore:                 "INSERT INTO item_stores (item, store, sold, section) SELECT ?, ?, ?, ? ON CONFLICT (item, store) DO UPDATE SET sold = excluded.sold, section = excluded.section",
+	queryKeyAutocompleteItems:                    "SELECT items.id, items.name, (SELECT COUNT(*) FROM purchases WHERE purchases.item = items.id) AS purchases FROM items WHERE items.name LIKE ? ESCAPE '\\' ORDER BY purchases DESC, items.name COLLATE NOCASE, items.id LIMIT ?",
+	queryKeyBumpDataVersion:                      "UPDATE data_version SET version = version + 1 RETURNING version",
+	queryKeyBumpDataVersionHighWater:             "UPDATE data_version_high_water SET version = ?1 WHERE version < ?1",
+	queryKeyClearList:                            "UPDATE items SET on_list = 0, updated_at = ? WHERE on_list = 1",
+	queryKeyCopyItemStoresToStore:                "INSERT INTO item_stores (item, store, sold, section) SELECT source.item, ?2, source.sold, (SELECT target_sections.id FROM sections AS source_sections JOIN sections AS target_sections ON lower(target_sections.name) = lower(source_sections.name) WHERE source_sections.id = source.section AND target_sections.store = ?2) FROM item_stores AS source WHERE source.store = ?1 ON CONFLICT (item, store) DO UPDATE SET sold = excluded.sold, section = excluded.section",
+	queryKeyCountItemStoresWithMatchingSection:   "SELECT COUNT(*) FROM item_stores AS source JOIN sections AS source_sections ON source_sections.id = source.section JOIN sections AS target_sections ON lower(target_sections.name) = lower(source_sections.name) AND target_sections.store = ?2 WHERE source.store = ?1",
+	queryKeyDeleteAllItems:                       "DELETE FROM items",
+	queryKeyDeleteAllStores:                      "DELETE FROM stores",
+	queryKeyDeleteAllTemplates:                   "DELETE FROM templates",
+	queryKeyDeleteAllTrips:                       "DELETE FROM trips",
+	queryKeyDeleteItem:                           "DELETE FROM items WHERE id = ?",
+	queryKeyDeleteSection:                        "DELETE FROM sections WHERE id = ?",
+	queryKeyDeleteStore:                          "DELETE FROM stores WHERE id = ?",
+	queryKeyEndTrip:                              "UPDATE trips SET ended_at = ? WHERE id = ?",
+	queryKeyExistsItemById:                       "SELECT EXISTS (SELECT 1 FROM items WHERE id = ?)",
+	queryKeyExistsItemByName:                     "SELECT EXISTS (SELECT 1 FROM items WHERE name = ?)",
+	queryKeyExistsOtherItemByName:                "SELECT EXISTS (SELECT 1 FROM items WHERE name = ? AND id != ?)",
+	queryKeyExistsOtherStoreByName:               "SELECT EXISTS (SELECT 1 FROM stores WHERE name = ? AND id != ?)",
+	queryKeyExistsSectionByStoreIdSectionId:      "SELECT EXISTS (SELECT 1 FROM sections WHERE store = ? AND id = ?)",
+	queryKeyExistsStoreById:                      "SELECT EXISTS (SELECT 1 FROM stores WHERE id = ?)",
+	queryKeyExistsTemplateById:                   "SELECT EXISTS (SELECT 1 FROM templates WHERE id = ?)",
+	queryKeyExistsTemplateByName:                 "SELECT EXISTS (SELECT 1 FROM templates WHERE name = ?)",
+	queryKeyGetActiveTrip:                        "SELECT id, store, started_at FROM trips WHERE ended_at IS NULL",
+	queryKeyGetActiveTripId:                      "SELECT id FROM trips WHERE ended_at IS NULL",
+	queryKeyGetChecksumItems:                     "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items ORDER BY id",
+	queryKeyGetChecksumItemStores:                "SELECT item, store, sold, section, order_index, price_cents FROM item_stores ORDER BY item, store",
+	queryKeyGetChecksumSections:                  "SELECT id, store, position, name, created_at, updated_at, aisle FROM sections ORDER BY id",
+	queryKeyGetChecksumStores:                    "SELECT id, name, created_at, updated_at, tax_rate, loyalty_note FROM stores ORDER BY id",
+	queryKeyGetDataVersion:                       "SELECT version FROM data_version",
+	queryKeyGetDeletedItemsSince:                 "SELECT id FROM deleted_items WHERE version > ?",
+	queryKeyGetDeletedItemStoresSince:            "SELECT item, store FROM deleted_item_stores WHERE version > ?",
+	queryKeyGetDeletedSectionsSince:              "SELECT id FROM deleted_sections WHERE version > ?",
+	queryKeyGetDeletedStoresSince:                "SELECT id FROM deleted_stores WHERE version > ?",
+	queryKeyGetDuplicateItems:                    "SELECT id, name, lower(name) FROM items WHERE lower(name) IN (SELECT lower(name) FROM items GROUP BY lower(name) HAVING COUNT(*) > 1) ORDER BY lower(name), id",
+	queryKeyGetEmptySectionIds:                   "SELECT id FROM sections WHERE store = ? AND NOT EXISTS (SELECT 1 FROM item_stores WHERE item_stores.section = sections.id AND item_stores.sold = 1) ORDER BY position, id",
+	queryKeyGetItem:                              "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items WHERE id = ?",
+	queryKeyGetItemIdByName:                      "SELECT id FROM items WHERE name = ?",
+	queryKeyGetItemName:                          "SELECT name FROM items WHERE id = ?",
+	queryKeyGetItemNames:                         "SELECT name FROM items",
+	queryKeyGetItemOnList:                        "SELECT on_list FROM items WHERE id = ?",
+	queryKeyGetItemStores:                        "SELECT item_stores.item, item_stores.store, item_stores.sold, item_stores.section, sections.position, item_stores.order_index, item_stores.price_cents FROM item_stores LEFT JOIN sections ON sections.id = item_stores.section",
+	queryKeyGetItems:                             "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items",
+	queryKeyGetItemsChangedSince:                 "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items WHERE updated_at > ? ORDER BY updated_at, id",
+	queryKeyGetItemsFiltered:                     "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items WHERE (?1 IS NULL OR (note IS NOT NULL) = ?1) AND (?2 IS NULL OR EXISTS (SELECT 1 FROM item_stores WHERE item = items.id AND store = ?2 AND sold = 1 AND (?3 IS NULL OR section = ?3)))",
+	queryKeyGetItemsSince:                        "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items WHERE version > ?",
+	queryKeyGetItemStoreCounts:                   "SELECT item, COUNT(*) FROM item_stores WHERE sold = 1 GROUP BY item",
+	queryKeyGetItemStoresByItem:                  "SELECT item, store, sold, section, order_index, price_cents FROM item_stores WHERE item = ? ORDER BY store",
+	queryKeyGetItemStoresBySection:               "SELECT item, store, sold, section, order_index, price_cents FROM item_stores WHERE section = ? ORDER BY item",
+	queryKeyGetItemStoresByStore:                 "SELECT item, store, sold, section, order_index, price_cents FROM item_stores WHERE store = ? ORDER BY item",
+	queryKeyGetItemStoresSince:                   "SELECT item_stores.item, item_stores.store, item_stores.sold, item_stores.section, sections.position, item_stores.order_index, item_stores.price_cents FROM item_stores LEFT JOIN sections ON sections.id = item_stores.section WHERE item_stores.version > ?1 OR sections.version > ?1",
+	queryKeyGetLayoutSections:                    "SELECT sections.id, sections.store, sections.position, sections.name, sections.aisle, COUNT(item_stores.item) FROM sections LEFT JOIN item_stores ON item_stores.section = sections.id AND item_stores.sold = 1 GROUP BY sections.id ORDER BY sections.store, sections.position, sections.id",
+	queryKeyGetLayoutStores:                      "SELECT stores.id, stores.name, COUNT(item_stores.item), COUNT(item_stores.item) - COUNT(item_stores.section) FROM stores LEFT JOIN item_stores ON item_stores.store = stores.id AND item_stores.sold = 1 GROUP BY stores.id ORDER BY stores.name COLLATE NOCASE, stores.id",
+	queryKeyGetListItemsNotSoldAtStore:           "SELECT id, name FROM items WHERE on_list = 1 AND NOT EXISTS (SELECT 1 FROM item_stores WHERE item_stores.item = items.id AND item_stores.store = ? AND item_stores.sold = 1) ORDER BY name",
+	queryKeyGetLowStockItems:                     "SELECT id, name, on_list FROM items WHERE low_stock = 1 ORDER BY name",
+	queryKeyGetNeededItems:                       "SELECT id, name FROM items WHERE on_list = 1 AND have = 0 ORDER BY name",
+	queryKeyGetOnListItems:                       "SELECT id, name, quantity, unit, note FROM items WHERE on_list = 1 ORDER BY name COLLATE NOCASE, id",
+	queryKeyGetOnListItemStores:                  "SELECT items.id, items.name, item_stores.store FROM items LEFT JOIN item_stores ON item_stores.item = items.id AND item_stores.sold = 1 WHERE items.on_list = 1 ORDER BY items.name, items.id, item_stores.store",
+	queryKeyGetOrphanListItems:                   "SELECT id, name FROM items WHERE on_list = 1 AND NOT EXISTS (SELECT 1 FROM item_stores WHERE item_stores.item = items.id AND item_stores.sold = 1) ORDER BY name",
+	queryKeyGetRecentItems:                       "SELECT id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at FROM items WHERE updated_at > 0 ORDER BY updated_at DESC, id DESC LIMIT ?",
+	queryKeyGetSection:                           "SELECT id, store, position, name, created_at, updated_at, aisle FROM sections WHERE id = ?",
+	queryKeyGetSectionIdByNameCaseInsensitive:    "SELECT id FROM sections WHERE store = ? AND lower(name) = lower(?)",
+	queryKeyGetSectionIdsByStore:                 "SELECT id FROM sections WHERE store = ? ORDER BY id",
+	queryKeyGetSectionItemsByNameCaseInsensitive: "SELECT stores.id, stores.name, sections.id, sections.name, items.id, items.name FROM sections JOIN stores ON stores.id = sections.store LEFT JOIN item_stores ON item_stores.section = sections.id AND item_stores.sold = 1 LEFT JOIN items ON items.id = item_stores.item WHERE lower(sections.name) = lower(?) ORDER BY stores.name COLLATE NOCASE, stores.id, items.name COLLATE NOCASE, items.id",
+	queryKeyGetSectionName:                       "SELECT name FROM sections WHERE id = ?",
+	queryKeyGetSectionPositionsByStore:           "SELECT id, position FROM sections WHERE store = ? ORDER BY position, id",
+	queryKeyGetSectionPositionsForRepair:         "SELECT id, store, position FROM sections WHERE (?1 IS NULL OR store = ?1) ORDER BY store, position, id",
+	queryKeyGetSections:                          "SELECT id, store, position, name, created_at, updated_at, aisle FROM sections",
+	queryKeyGetSectionsByNameCaseInsensitive:     "SELECT stores.id, stores.name, sections.id, sections.name FROM sections JOIN stores ON stores.id = sections.store WHERE lower(sections.name) = lower(?) ORDER BY stores.name COLLATE NOCASE, stores.id",
+	queryKeyGetSectionsByStore:                   "SELECT id, store, position, name, created_at, updated_at, aisle FROM sections WHERE store = ? ORDER BY position, id",
+	queryKeyGetSectionsSince:                     "SELECT id, store, position, name, created_at, updated_at, aisle FROM sections WHERE version > ?",
+	queryKeyGetSectionStore:                      "SELECT store FROM sections WHERE id = ?",
+	queryKeyGetStore:                             "SELECT id, name, created_at, updated_at, tax_rate, loyalty_note FROM stores WHERE id = ?",
+	queryKeyGetStoreCompleteness:                 "SELECT stores.id, COUNT(item_stores.item), COUNT(item_stores.section), CAST(COUNT(item_stores.section) AS REAL) / NULLIF(COUNT(item_stores.item), 0) FROM stores LEFT JOIN item_stores ON item_stores.store = stores.id AND item_stores.sold = 1 GROUP BY stores.id ORDER BY stores.id",
+	queryKeyGetStoreIdByName:                     "SELECT id FROM stores WHERE name = ?",
+	queryKeyGetStoreName:                         "SELECT name FROM stores WHERE id = ?",
+	queryKeyGetStores:                            "SELECT id, name, created_at, updated_at, tax_rate, loyalty_note FROM stores",
+	queryKeyGetStoresByRecent:                    "SELECT stores.id, stores.name, stores.created_at, stores.updated_at, stores.tax_rate, stores.loyalty_note FROM stores LEFT JOIN (SELECT store, MAX(at) AS at FROM (SELECT store, bought_at AS at FROM purchases UNION ALL SELECT store, started_at AS at FROM trips) GROUP BY store) AS last_shopped ON last_shopped.store = stores.id ORDER BY last_shopped.at IS NULL, last_shopped.at DESC, stores.name",
+	queryKeyGetStoreSectionItemOrder:             "SELECT item_stores.item, item_stores.order_index FROM item_stores JOIN items ON items.id = item_stores.item WHERE item_stores.store = ? AND item_stores.sold = 1 AND item_stores.section IS ? ORDER BY item_stores.order_index, items.name, items.id",
+	queryKeyGetStoreSoldCounts:                   "SELECT COUNT(*), COUNT(section) FROM item_stores WHERE store = ? AND sold = 1",
+	queryKeyGetStoresSince:                       "SELECT id, name, created_at, updated_at, tax_rate, loyalty_note FROM stores WHERE version > ?",
+	queryKeyGetSyncFloor:                         "SELECT version FROM sync_floor",
+	queryKeyGetTableCounts:                       "SELECT (SELECT COUNT(*) FROM items), (SELECT COUNT(*) FROM stores), (SELECT COUNT(*) FROM sections), (SELECT COUNT(*) FROM item_stores)",
+	queryKeyGetTemplateItems:                     "SELECT template_items.template, template_items.item, COALESCE(items.name, template_items.name) AS name FROM template_items LEFT JOIN items ON items.id = template_items.item ORDER BY template_items.template, name",
+	queryKeyGetTemplates:                         "SELECT id, name, created_at FROM templates ORDER BY name",
+	queryKeyGetTotalChanges:                      "SELECT total_changes()",
+	queryKeyGetTripItemIds:                       "SELECT item FROM trip_items WHERE trip = ? ORDER BY item",
+	queryKeyGetUnlinkedTemplateItemNames:         "SELECT name FROM template_items WHERE template = ? AND item IS NULL ORDER BY name",
+	queryKeyGetUnusedItems:                       "SELECT id, name FROM items WHERE on_list = 0 AND NOT EXISTS (SELECT 1 FROM item_stores WHERE item_stores.item = items.id) ORDER BY name COLLATE NOCASE, id",
+	queryKeyImportItem:                           "INSERT INTO items (id, name, on_list, low_stock, have, quantity, unit, note, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	queryKeyImportItemStore:                      "INSERT INTO item_stores (item, store, sold, section, order_index, price_cents) VALUES (?, ?, ?, ?, ?, ?)",
+	queryKeyImportSection:                        "INSERT INTO sections (id, store, position, name, created_at, updated_at, aisle) VALUES (?, ?, ?, ?, ?, ?, ?)",
+	queryKeyImportStore:                          "INSERT INTO stores (id, name, created_at, updated_at, tax_rate, loyalty_note) VALUES (?, ?, ?, ?, ?, ?)",
+	queryKeyInsertItem:                           "INSERT INTO items (name, on_list, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id",
+	queryKeyInsertPurchase:                       "INSERT INTO purchases (item, store, trip, bought_at, quantity) SELECT id, ?, NULL, ?, COALESCE(?, quantity) FROM items WHERE id = ? RETURNING id, quantity",
+	queryKeyInsertSection:                        "INSERT INTO sections (store, position, name, created_at, updated_at, aisle) VALUES (?, COALESCE((SELECT MAX(position) + 1 FROM sections WHERE store = ?), 0), ?, ?, ?, ?) RETURNING id, position",
+	queryKeyInsertSectionPurchases:               "INSERT INTO purchases (item, store, trip, bought_at, quantity) SELECT items.id, item_stores.store, NULL, ?, items.quantity FROM items JOIN item_stores ON item_stores.item = items.id WHERE items.on_list = 1 AND item_stores.store = ? AND item_stores.section = ?",
+	queryKeyInsertStore:                          "INSERT INTO stores (name, created_at, updated_at, tax_rate, loyalty_note) VALUES (?, ?, ?, ?, ?) ON CONFLICT (name) DO NOTHING RETURNING id",
+	queryKeyInsertStorePurchases:                 "INSERT INTO purchases (item, store, trip, bought_at, quantity) SELECT items.id, item_stores.store, NULL, ?, items.quantity FROM items JOIN item_stores ON item_stores.item = items.id WHERE items.on_list = 1 AND item_stores.store = ? AND item_stores.sold = 1",
+	queryKeyInsertTemplate:                       "INSERT INTO templates (name, created_at) VALUES (?, ?) RETURNING id",
+	queryKeyInsertTemplateItemsFromList:          "INSERT INTO template_items (template, item, name) SELECT ?, id, name FROM items WHERE on_list = 1",
+	queryKeyInsertTrip:                           "INSERT INTO trips (store, started_at) VALUES (?, ?) RETURNING id",
+	queryKeyInsertTripItem:                       "INSERT INTO trip_items (trip, item) VALUES (?, ?) ON CONFLICT DO NOTHING",
+	queryKeyInsertTripPurchases:                  "INSERT INTO purchases (item, store, trip, bought_at, quantity) SELECT trip_items.item, trips.store, trips.id, ?, items.quantity FROM trip_items JOIN trips ON trips.id = trip_items.trip JOIN items ON items.id = trip_items.item WHERE trip_items.trip = ?",
+	queryKeyItemOffList:                          "UPDATE items SET on_list = 0, updated_at = ? WHERE id = ?",
+	queryKeyItemOnList:                           "UPDATE items SET on_list = 1, updated_at = ? WHERE id = ?",
+	queryKeyItemOnListWithDetails:                "UPDATE items SET on_list = 1, quantity = IIF(?, ?, quantity), unit = IIF(?, ?, unit), note = IIF(?, ?, note), updated_at = ? WHERE id = ?",
+	queryKeyItemStoreHasSection:                  "SELECT EXISTS (SELECT 1 FROM item_stores WHERE item = ? AND store = ? AND section IS NOT NULL)",
+	queryKeyLinkTemplateItem:                     "UPDATE template_items SET item = ? WHERE template = ? AND name = ?",
+	queryKeyLinkTemplateItemsByName:              "UPDATE template_items SET item = (SELECT id FROM items WHERE items.name = template_items.name) WHERE template = ? AND item IS NULL",
+	queryKeyMoveItemStoresToSection:              "UPDATE item_stores SET section = ? WHERE store = ? AND section = ?",
+	queryKeyRaiseSyncFloor:                       "UPDATE sync_floor SET version = ?1 WHERE version < ?1",
+	queryKeyRenameUnit:                           "UPDATE items SET unit = ?1, updated_at = ?2 WHERE (unit = ?3 COLLATE NOCASE OR unit = ?4) AND unit IS NOT ?1",
+	queryKeySectionItemsOffList:                  "UPDATE items SET on_list = 0, updated_at = ? WHERE on_list = 1 AND id IN (SELECT item FROM item_stores WHERE store = ? AND section = ?)",
+	queryKeySectionItemsOnList:                   "UPDATE items SET on_list = 1, updated_at = ? WHERE on_list = 0 AND id IN (SELECT item FROM item_stores WHERE store = ? AND section = ?)",
+	queryKeyStoreItemsOffList:                    "UPDATE items SET on_list = 0, updated_at = ? WHERE on_list = 1 AND id IN (SELECT item FROM item_stores WHERE store = ? AND sold = 1)",
+	queryKeyTemplateItemsOnList:                  "UPDATE items SET on_list = 1, updated_at = ? WHERE on_list = 0 AND id IN (SELECT item FROM template_items WHERE template = ?)",
+	queryKeyTripItemsOffList:                     "UPDATE items SET on_list = 0, updated_at = ? WHERE id IN (SELECT item FROM trip_items WHERE trip = ?)",
+	queryKeyUpdateItemHave:                       "UPDATE items SET have = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateItemLowStock:                   "UPDATE items SET low_stock = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateItemName:                       "UPDATE items SET name = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateItemQuantity:                   "UPDATE items SET quantity = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateItemStoreOrderIndex:            "UPDATE item_stores SET order_index = ? WHERE item = ? AND store = ?",
+	queryKeyUpdateItemStorePrice:                 "UPDATE item_stores SET price_cents = ? WHERE item = ? AND store = ?",
+	queryKeyUpdateItemStoreSold:                  "UPDATE item_stores SET sold = ? WHERE item = ? AND store = ? RETURNING section",
+	queryKeyUpdateItemUnit:                       "UPDATE items SET unit = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateSectionAisle:                   "UPDATE sections SET aisle = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateSectionName:                    "UPDATE sections SET name = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateSectionPosition:                "UPDATE sections SET position = ?, updated_at = ? WHERE id = ? AND store = ? AND position != ?",
+	queryKeyUpdateStoreMeta:                      "UPDATE stores SET tax_rate = ?, loyalty_note = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpdateStoreName:                      "UPDATE stores SET name = ?, updated_at = ? WHERE id = ?",
+	queryKeyUpsertItemStore:                      "INSERT INTO item_stores (item, store, sold, section) SELECT ?, ?, ?, ? ON CONFLICT (item, store) DO UPDATE SET sold = excluded.sold, section = excluded.section",
+	queryKeyUpsertItemStoreSold:                  "INSERT INTO item_stores (item, store, sold) SELECT ?, ?, 1 ON CONFLICT (item, store) DO UPDATE SET sold = 1",
 }
 
 var preparedQueries = map[queryKey]*sql.Stmt{}
 
+// The database's schema version, and the newest one this binary has a migration for (they differ only if the database
+// was last opened by a newer binary). Set once at startup.
+var schemaVersion int
+var knownSchemaVersion int
+
+// Per-query execution stats, only collected when SHOPPING_DEBUG_QUERIES=1.
+type queryStat struct {
+	count    int64
+	duration time.Duration
+}
+
+var queryStats = map[queryKey]queryStat{}
+var queryStatsMutex sync.Mutex
+
+func recordQueryStat(key queryKey, t0 time.Time) {
+	if !shoppingDebugQueries {
+		return
+	}
+	duration := time.Since(t0)
+	queryStatsMutex.Lock()
+	defer queryStatsMutex.Unlock()
+	stats := queryStats[key]
+	stats.count++
+	stats.duration += duration
+	queryStats[key] = stats
+}
+
 var shoppingDataDir = "/var/lib/shopping"
 var shoppingAddr = ":80"
+var shoppingItemsCacheMaxBytes = 16 * 1024 * 1024
+var shoppingDebugQueries = false
+var shoppingServerTiming = false
+var shoppingAllowReset = false
+var shoppingDefaultOnList = false
+var shoppingRefuseNewerSchema = false
+var shoppingMaxItemName = 200
+var shoppingMaxSectionName = 100
+var shoppingMaxStoreName = 100
+var shoppingMaxTemplateName = 100
+var shoppingDbLockTimeout = 30 * time.Second
+var shoppingBackupDir = ""
+var shoppingBackupInterval = 24 * time.Hour
+var shoppingBackupKeep = 7
+var shoppingCheckpointInterval = time.Duration(0)
+var shoppingLocale *language.Tag
 
 func init() {
 	if v := os.Getenv("SHOPPING_DATA_DIR"); v != "" {
@@ -106,6 +380,87 @@ func init() {
 	if v := os.Getenv("SHOPPING_ADDR"); v != "" {
 		shoppingAddr = v
 	}
+	if v := os.Getenv("SHOPPING_ITEMS_CACHE_MAX_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: parsing SHOPPING_ITEMS_CACHE_MAX_BYTES: %v\n", err)
+			os.Exit(1)
+		}
+		shoppingItemsCacheMaxBytes = n
+	}
+	if v := os.Getenv("SHOPPING_DEBUG_QUERIES"); v == "1" {
+		shoppingDebugQueries = true
+	}
+	if v := os.Getenv("SHOPPING_ALLOW_RESET"); v == "1" {
+		shoppingAllowReset = true
+	}
+	if v := os.Getenv("SHOPPING_DEFAULT_ON_LIST"); v == "1" {
+		shoppingDefaultOnList = true
+	}
+	if v := os.Getenv("SHOPPING_REFUSE_NEWER_SCHEMA"); v == "1" {
+		shoppingRefuseNewerSchema = true
+	}
+	if v := os.Getenv("SHOPPING_SERVER_TIMING"); v == "1" {
+		shoppingServerTiming = true
+	}
+	for name, max := range map[string]*int{
+		"SHOPPING_MAX_ITEM_NAME":     &shoppingMaxItemName,
+		"SHOPPING_MAX_SECTION_NAME":  &shoppingMaxSectionName,
+		"SHOPPING_MAX_STORE_NAME":    &shoppingMaxStoreName,
+		"SHOPPING_MAX_TEMPLATE_NAME": &shoppingMaxTemplateName,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "error: parsing %s: must be a positive integer\n", name)
+				os.Exit(1)
+			}
+			*max = n
+		}
+	}
+	if v := os.Getenv("SHOPPING_DB_LOCK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: parsing SHOPPING_DB_LOCK_TIMEOUT: %v\n", err)
+			os.Exit(1)
+		}
+		shoppingDbLockTimeout = d
+	}
+	if v := os.Getenv("SHOPPING_BACKUP_DIR"); v != "" {
+		shoppingBackupDir = v
+	}
+	if v := os.Getenv("SHOPPING_BACKUP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "error: parsing SHOPPING_BACKUP_INTERVAL: must be a positive duration\n")
+			os.Exit(1)
+		}
+		shoppingBackupInterval = d
+	}
+	if v := os.Getenv("SHOPPING_BACKUP_KEEP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "error: parsing SHOPPING_BACKUP_KEEP: must be a positive integer\n")
+			os.Exit(1)
+		}
+		shoppingBackupKeep = n
+	}
+	if v := os.Getenv("SHOPPING_LOCALE"); v != "" {
+		tag, err := language.Parse(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: parsing SHOPPING_LOCALE: %v\n", err)
+			os.Exit(1)
+		}
+		shoppingLocale = &tag
+	}
+	if v := os.Getenv("SHOPPING_CHECKPOINT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			fmt.Fprintf(os.Stderr, "error: parsing SHOPPING_CHECKPOINT_INTERVAL: must be a non-negative duration\n")
+			os.Exit(1)
+		}
+		shoppingCheckpointInterval = d
+	}
 }
 
 func main() {
@@ -116,25 +471,211 @@ func main() {
 	}
 }
 
+// Steps to run before particular migrations (in the same transaction), to get existing data into a shape they can
+// handle, rather than letting them fail with an opaque constraint violation.
+var migrationPreparations = map[string]func(tx *sql.Tx) error{
+	"0015.sql": renameDuplicateSectionNames,
+}
+
+// 0015.sql makes section names unique within a store, ignoring case. Before it runs, rename all but the oldest of each
+// group of sections whose names differ only by case, by adding " (2)", " (3)", and so on (skipping names that are
+// taken), so that nobody's database refuses to start over it.
+func renameDuplicateSectionNames(tx *sql.Tx) error {
+	type section struct {
+		id        int64
+		store     int64
+		name      string
+		lowerName string
+	}
+	type storeName struct {
+		store     int64
+		lowerName string
+	}
+
+	// lower() is what the index compares, so fold names with it rather than in Go.
+	rows, err := tx.Query("SELECT id, store, name, lower(name) FROM sections ORDER BY store, id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sections := []section{}
+	taken := map[storeName]bool{}
+	for rows.Next() {
+		var s section
+		err = rows.Scan(&s.id, &s.store, &s.name, &s.lowerName)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, s)
+		taken[storeName{s.store, s.lowerName}] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	renamed := false
+	seen := map[storeName]bool{}
+	for _, s := range sections {
+		key := storeName{s.store, s.lowerName}
+		if !seen[key] {
+			seen[key] = true
+			continue
+		}
+		n := 2
+		for taken[storeName{s.store, fmt.Sprintf("%s (%d)", s.lowerName, n)}] {
+			n++
+		}
+		name := fmt.Sprintf("%s (%d)", s.name, n)
+		taken[storeName{s.store, fmt.Sprintf("%s (%d)", s.lowerName, n)}] = true
+		slog.Warn("renaming section whose name differs from another's only by case", "id", s.id, "from", s.name, "to", name)
+		_, err = tx.Exec("UPDATE sections SET name = ? WHERE id = ?", name, s.id)
+		if err != nil {
+			return err
+		}
+		renamed = true
+	}
+
+	// Clients have the old names, so make sure they refetch.
+	if renamed {
+		_, err = tx.Exec("UPDATE data_version SET version = version + 1")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Closed when the server starts shutting down, so that long-lived responses (GET /api/events, GET /api/ws), which
+// would otherwise hold up shutdown, can end.
+var shuttingDown = make(chan struct{})
+
+// How long to wait, on SIGINT or SIGTERM, for in-flight requests to finish.
+const shutdownTimeout = 10 * time.Second
+
 func main_serve() error {
-	path := filepath.Join(shoppingDataDir, "shopping.db")
+	// index.html is served relative to the working directory. If it isn't there (e.g. we were started from the wrong
+	// directory), every page would 404 while the API happily works, which is confusing, so just refuse to start
+	// (before touching the database, which opening would create and migrate).
+	_, err := os.Stat("index.html")
+	if err != nil {
+		workingDir, _ := os.Getwd()
+		return fmt.Errorf("index.html not found in working directory %s (is shopping running from the right directory?): %w\n", workingDir, err)
+	}
+
+	db, err := openDatabase(filepath.Join(shoppingDataDir, "shopping.db"))
+	if err != nil {
+		return err
+	}
+	closeOnReturn := true
+	defer func() {
+		if closeOnReturn {
+			closeDatabase(db)
+		}
+	}()
+
+	mux := newServeMux(db)
+
+	// Background jobs stop when ctx is done, and must have stopped before the database is checkpointed and closed.
+	var background sync.WaitGroup
+	defer background.Wait()
+
+	// Run until SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Periodically back up the database, if configured to.
+	if shoppingBackupDir != "" {
+		err = os.MkdirAll(shoppingBackupDir, 0o755)
+		if err != nil {
+			return fmt.Errorf("creating backup directory: %w\n", err)
+		}
+		background.Go(func() {
+			runScheduledBackups(ctx, db, shoppingBackupDir, shoppingBackupInterval, shoppingBackupKeep)
+		})
+	}
+
+	// Periodically checkpoint the WAL, if configured to.
+	if shoppingCheckpointInterval > 0 {
+		background.Go(func() {
+			runScheduledCheckpoints(ctx, db, shoppingCheckpointInterval)
+		})
+	}
+
+	server := &http.Server{
+		Addr:    shoppingAddr,
+		Handler: crashOnPanicMiddleware(requestLoggingMiddleware(gzipMiddleware(mux)))}
+	server.RegisterOnShutdown(func() { close(shuttingDown) })
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	slog.Info("server running", "addr", shoppingAddr)
+	select {
+	case err = <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections, and give in-flight requests a while to finish. A second signal kills us right away.
+	stop()
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		// Those requests may still be using the database, so leave it be: exiting is safe (SQLite will recover the
+		// WAL next time), but closing it under them isn't.
+		closeOnReturn = false
+		return fmt.Errorf("in-flight requests didn't finish in time: %w\n", err)
+	}
+	background.Wait()
+
+	// Fold the WAL back into the database file, so it's left whole. (Deferred calls then close the prepared
+	// statements and the database.)
+	var busy, log, checkpointed int64
+	err = db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &log, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("checkpointing WAL: %w\n", err)
+	}
+	slog.Info("checkpointed WAL", "busy", busy, "log", log, "checkpointed", checkpointed)
+	return nil
+}
 
+// Open the database file (creating it if need be), bring its schema up to date, and prepare queries. Close it with
+// closeDatabase.
+func openDatabase(path string) (*sql.DB, error) {
 	// Determine whether we are creating a new database file.
 	isNew := false
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		isNew = true
 	} else if err != nil {
-		return fmt.Errorf("checking database file: %w\n", err)
+		return nil, fmt.Errorf("checking database file: %w\n", err)
 	}
 
 	// Open the database file.
 	db, err := sql.Open("sqlite", path)
 	if err != nil {
-		return fmt.Errorf("opening database file %s: %w\n", path, err)
+		return nil, fmt.Errorf("opening database file %s: %w\n", path, err)
+	}
+
+	err = setUpDatabase(db, isNew)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
-	defer db.Close()
+	return db, nil
+}
+
+func closeDatabase(db *sql.DB) {
+	for _, stmt := range preparedQueries {
+		stmt.Close()
+	}
+	db.Close()
+}
 
+func setUpDatabase(db *sql.DB, isNew bool) error {
 	// Use up to 1 connection, don't close it when idle. By literally serializing all writes, we get to avoid
 	// writing retry-on-busy loops that we'd otherwise get in the presence of concurrent writes (which should be
 	// very rare anyway). Our queries are extremely small and fast, so serializing writes is totally fine. Using
@@ -144,14 +685,16 @@ func main_serve() error {
 	db.SetMaxIdleConns(1)
 	db.SetMaxOpenConns(1)
 
-	// Enable WAL mode (persists on database, but fine to set again and again).
-	_, err = db.Exec("PRAGMA journal_mode = WAL")
+	// Enable WAL mode (persists on database, but fine to set again and again). This is the first statement that touches
+	// the database file, so if another process (e.g. the previous instance, during a deploy) still holds a lock on it,
+	// this is where we find out; wait a while for it to go away.
+	err := execRetryingWhileLocked(db, "PRAGMA journal_mode = WAL", shoppingDbLockTimeout)
 	if err != nil {
 		return fmt.Errorf("setting journal mode to WAL: %w\n", err)
 	}
 
 	// Enable foreign key integrity checking on the connection.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	err = execRetryingWhileLocked(db, "PRAGMA foreign_keys = ON", shoppingDbLockTimeout)
 	if err != nil {
 		return fmt.Errorf("enabling foreign keys: %w\n", err)
 	}
@@ -167,28 +710,32 @@ func main_serve() error {
 	}
 
 	// Read migration files, keeping only those newer than the current version.
-	entries, err := fs.ReadDir(migrationFS, "migrations")
+	migrations, newestVersion, err := readMigrations(migrationFS, currentSchemaVersion)
 	if err != nil {
-		return fmt.Errorf("reading migrations directory: %w\n", err)
+		return err
 	}
 
-	migrations := []string{}
-	highest := currentSchemaVersion
-	for _, entry := range entries {
-		var n int
-		name := entry.Name()
-		n, err = strconv.Atoi(strings.TrimSuffix(name, ".sql"))
-		if err != nil {
-			return fmt.Errorf("parsing %v as int: %w\n", entry, err)
-		}
-		if n > currentSchemaVersion {
-			migrations = append(migrations, name)
-			highest = n
+	// If the database is newer than any migration we know about, a newer binary has run against it (and this one was
+	// probably deployed by mistake). We may be fine, or may not; say so loudly, and refuse to go on if asked to.
+	knownSchemaVersion = newestVersion
+	if currentSchemaVersion > knownSchemaVersion {
+		if shoppingRefuseNewerSchema {
+			return fmt.Errorf(
+				"database schema version %d is newer than this binary knows about (%d)\n",
+				currentSchemaVersion,
+				knownSchemaVersion)
 		}
+		slog.Warn(
+			"DATABASE SCHEMA IS NEWER THAN THIS BINARY KNOWS ABOUT; was an older version deployed by mistake?",
+			"schema_version", currentSchemaVersion,
+			"known_schema_version", knownSchemaVersion)
 	}
+	schemaVersion = max(currentSchemaVersion, knownSchemaVersion)
 
-	// Run migrations.
-	for _, name := range migrations {
+	// Run migrations, each in its own transaction along with the schema_version update, so that if one fails, the ones
+	// before it are recorded as applied and won't be run again next time.
+	for _, migration := range migrations {
+		name := migration.name
 		if !isNew {
 			slog.Info("running migration", "name", name)
 		}
@@ -204,24 +751,75 @@ func main_serve() error {
 		}
 		defer tx.Rollback()
 
+		if prepare, ok := migrationPreparations[name]; ok && !isNew {
+			err = prepare(tx)
+			if err != nil {
+				return fmt.Errorf("preparing for migration %s: %w\n", name, err)
+			}
+		}
+
 		_, err = tx.Exec(string(bytes))
 		if err != nil {
 			return fmt.Errorf("executing migration %s: %w\n", name, err)
 		}
 
+		_, err = tx.Exec("UPDATE schema_version SET version = ?", migration.version)
+		if err != nil {
+			return err
+		}
+
 		err = tx.Commit()
 		if err != nil {
 			return err
 		}
 	}
 
-	// Update schema_version if any migrations were applied.
-	if highest > currentSchemaVersion {
-		_, err = db.Exec("UPDATE schema_version SET version = ?", highest)
+	// Every handler assumes data_version has exactly one row. If it somehow has none (a bad manual edit, say), every
+	// request would fail, so put it back.
+	var dataVersionRows int
+	err = db.QueryRow("SELECT COUNT(*) FROM data_version").Scan(&dataVersionRows)
+	if err != nil {
+		return fmt.Errorf("checking data version: %w\n", err)
+	}
+	if dataVersionRows == 0 {
+		slog.Warn("data_version table is empty; resetting data version to 0")
+		_, err = db.Exec("INSERT INTO data_version (version) VALUES (0)")
 		if err != nil {
-			return err
+			return fmt.Errorf("resetting data version: %w\n", err)
+		}
+	}
+
+	// The data version must never go backwards, else clients holding a newer version would think they're up to date.
+	// But it can, if an older copy of the data is put back (or the self-heal above kicks in), so if it's behind the
+	// highest version ever handed out, skip past that.
+	var dataVersion, highWater int64
+	err = db.QueryRow(
+		"SELECT (SELECT version FROM data_version), (SELECT COALESCE(MAX(version), 0) FROM data_version_high_water)",
+	).Scan(&dataVersion, &highWater)
+	if err != nil {
+		return fmt.Errorf("checking data version high-water mark: %w\n", err)
+	}
+	if dataVersion < highWater {
+		slog.Warn(
+			"data version is behind its high-water mark; fast-forwarding",
+			"data_version", dataVersion,
+			"high_water", highWater)
+		_, err = db.Exec("UPDATE data_version SET version = ?", highWater+1)
+		if err != nil {
+			return fmt.Errorf("fast-forwarding data version: %w\n", err)
+		}
+
+		// The rows' version stamps came from the older copy, so no client can sync incrementally across this.
+		_, err = db.Exec(queries[queryKeyRaiseSyncFloor], highWater+1)
+		if err != nil {
+			return fmt.Errorf("raising sync floor: %w\n", err)
 		}
 	}
+	_, err = db.Exec(
+		"DELETE FROM data_version_high_water; INSERT INTO data_version_high_water (version) SELECT version FROM data_version")
+	if err != nil {
+		return fmt.Errorf("updating data version high-water mark: %w\n", err)
+	}
 
 	// Prepare queries
 	for key, query := range queries {
@@ -229,10 +827,44 @@ func main_serve() error {
 		if err != nil {
 			return err
 		}
-		defer stmt.Close()
 		preparedQueries[key] = stmt
 	}
+	return nil
+}
+
+type migration struct {
+	name    string
+	version int
+}
+
+// The migrations in fsys's migrations directory that are newer than the current schema version, and the newest version
+// there is. They must be numbered 0, 1, 2, ... without gaps: a missing number is probably a deleted migration, and if
+// it were later put back, it would never run (it would be older than the schema version).
+func readMigrations(fsys fs.FS, currentSchemaVersion int) ([]migration, int, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading migrations directory: %w\n", err)
+	}
+
+	migrations := []migration{}
+	for i, entry := range entries {
+		var n int
+		name := entry.Name()
+		n, err = strconv.Atoi(strings.TrimSuffix(name, ".sql"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("parsing %v as int: %w\n", entry, err)
+		}
+		if n != i {
+			return nil, 0, fmt.Errorf("migration %04d is missing (found %s instead)\n", i, name)
+		}
+		if n > currentSchemaVersion {
+			migrations = append(migrations, migration{name: name, version: n})
+		}
+	}
+	return migrations, len(entries) - 1, nil
+}
 
+func newServeMux(db *sql.DB) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Single-page app routes
@@ -272,29 +904,101 @@ func main_serve() error {
 		})
 	}
 
+	defineHandler("GET /api/autocomplete", handleAutocomplete)
+	defineHandler("GET /api/check-name", handleCheckName)
+	defineHandler("GET /api/checksum", handleGetChecksum)
+	if shoppingDebugQueries {
+		defineHandler("GET /api/debug/queries", handleDebugQueries)
+	}
+	defineHandler("GET /api/duplicates", handleGetDuplicates)
+	defineHandler("GET /api/events", handleGetEvents)
+	defineHandler("GET /api/item/{id}", handleGetItem)
 	defineHandler("GET /api/items", handleGetItems)
+	defineHandler("GET /api/items/changed-since", handleGetItemsChangedSince)
+	defineHandler("GET /api/items/recent", handleGetRecentItems)
+	defineHandler("GET /api/items/unused", handleGetUnusedItems)
+	defineHandler("GET /api/layouts", handleGetLayouts)
+	defineHandler("GET /api/list/coverage-gaps", handleGetListCoverageGaps)
+	defineHandler("GET /api/list/export", handleGetListExport)
+	defineHandler("GET /api/list/store-coverage", handleGetListStoreCoverage)
+	defineHandler("GET /api/list/orphans", handleGetListOrphans)
+	defineHandler("GET /api/low-stock", handleGetLowStock)
+	defineHandler("GET /api/needed", handleGetNeeded)
+	defineHandler("GET /api/sections/by-name", handleGetSectionsByName)
+	defineHandler("GET /api/sections/items", handleGetSectionItems)
+	defineHandler("GET /api/store-stats", handleGetStoreStats)
+	defineHandler("GET /api/store/{id}/health", handleGetStoreHealth)
+	defineHandler("GET /api/stores", handleGetStores)
+	defineHandler("GET /api/sync-status", handleGetSyncStatus)
+	defineHandler("GET /api/templates", handleGetTemplates)
+	defineHandler("GET /api/trip", handleGetTrip)
+	defineHandler("GET /api/units", handleGetUnits)
+	defineHandler("GET /api/version", handleGetVersion)
+	defineHandler("GET /api/ws", handleWebSocket)
+	defineHandler("POST /api/apply-template", handleApplyTemplate)
+	defineHandler("POST /api/batch-rename", handleBatchRename)
+	defineHandler("POST /api/checkout", handleCheckout)
+	defineHandler("POST /api/clear-list", handleClearList)
 	defineHandler("POST /api/create-item", handleCreateItem)
+	defineHandler("POST /api/create-items", handleCreateItems)
 	defineHandler("POST /api/create-section", handleCreateSection)
 	defineHandler("POST /api/create-store", handleCreateStore)
 	defineHandler("POST /api/delete-item", handleDeleteItem)
 	defineHandler("POST /api/delete-section", handleDeleteSection)
 	defineHandler("POST /api/delete-store", handleDeleteStore)
+	defineHandler("POST /api/end-trip", handleEndTrip)
+	defineHandler("POST /api/import", handleImport)
+	defineHandler("POST /api/import-text", handleImportText)
 	defineHandler("POST /api/item-in-store", handleItemInStore)
 	defineHandler("POST /api/item-not-in-store", handleItemNotInStore)
 	defineHandler("POST /api/item-off", handleItemOff)
 	defineHandler("POST /api/item-on", handleItemOn)
+	defineHandler("POST /api/mark-bought", handleMarkBought)
+	defineHandler("POST /api/merge-sections", handleMergeSections)
+	defineHandler("POST /api/move-section", handleMoveSection)
+	defineHandler("POST /api/move-section-before", handleMoveSectionBefore)
 	defineHandler("POST /api/rename-item", handleRenameItem)
 	defineHandler("POST /api/rename-section", handleRenameSection)
 	defineHandler("POST /api/rename-store", handleRenameStore)
+	defineHandler("POST /api/rename-unit", handleRenameUnit)
 	defineHandler("POST /api/reorder-sections", handleReorderSections)
+	defineHandler("POST /api/reorder-store-items", handleReorderStoreItems)
+	defineHandler("POST /api/repair-positions", handleRepairPositions)
+	if shoppingAllowReset {
+		defineHandler("POST /api/reset", handleReset)
+	}
+	defineHandler("POST /api/restore-store", handleRestoreStore)
+	defineHandler("POST /api/save-template", handleSaveTemplate)
+	defineHandler("POST /api/section-items-off", handleSectionItemsOff)
+	defineHandler("POST /api/section-items-on", handleSectionItemsOn)
+	defineHandler("POST /api/set-item-have", handleSetItemHave)
+	defineHandler("POST /api/set-item-low-stock", handleSetItemLowStock)
+	defineHandler("POST /api/set-item-price", handleSetItemPrice)
+	defineHandler("POST /api/set-item-sold", handleSetItemSold)
+	defineHandler("POST /api/set-item-unit", handleSetItemUnit)
+	defineHandler("POST /api/set-section-aisle", handleSetSectionAisle)
+	defineHandler("POST /api/start-trip", handleStartTrip)
+	defineHandler("POST /api/store-items-off", handleStoreItemsOff)
+	defineHandler("POST /api/transfer-store-items", handleTransferStoreItems)
+	defineHandler("POST /api/trip-buy-item", handleTripBuyItem)
+	defineHandler("POST /api/update-section", handleUpdateSection)
+	defineHandler("POST /api/update-store-meta", handleUpdateStoreMeta)
+
+	return mux
+}
 
-	slog.Info("server running", "addr", shoppingAddr)
-	return http.ListenAndServe(shoppingAddr, crashOnPanicMiddleware(requestLoggingMiddleware(mux)))
+// Add "; charset=utf-8" to a textual content type that doesn't already say what its charset is. (All of our text is
+// UTF-8, and some strict clients complain if they're not told).
+func withUtf8Charset(contentType string) string {
+	if !strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "charset=") {
+		return contentType
+	}
+	return contentType + "; charset=utf-8"
 }
 
 func serveStaticFile(mux *http.ServeMux, pattern string, contentType string, file string) {
 	mux.HandleFunc(pattern, func(response http.ResponseWriter, request *http.Request) {
-		response.Header().Set("Content-Type", contentType)
+		response.Header().Set("Content-Type", withUtf8Charset(contentType))
 		http.ServeFile(response, request, file)
 	})
 }
@@ -304,50 +1008,62 @@ func serveStaticFile(mux *http.ServeMux, pattern string, contentType string, fil
 func serveHashedStaticFile(mux *http.ServeMux, pattern string, contentType string, file string) {
 	mux.HandleFunc(pattern, func(response http.ResponseWriter, request *http.Request) {
 		response.Header().Set("Cache-Control", "max-age=31536000, immutable")
-		response.Header().Set("Content-Type", contentType)
+		response.Header().Set("Content-Type", withUtf8Charset(contentType))
 		http.ServeFile(response, request, file)
 	})
 }
 
-// GET /api/items
-func handleGetItems(handler *Handler) {
-	// Begin transaction
-	err := handler.SqliteBeginTransaction()
-	if err != nil {
-		handler.InternalServerError(err)
-		return
+// GET /api/autocomplete?q=mi&limit=10
+//
+// Items whose names start with q (ignoring case), most-purchased first, then by name. limit defaults to 10, and is at
+// most 100.
+func handleAutocomplete(handler *Handler) {
+	query := handler.request.URL.Query()
+	prefix := strings.TrimLeft(query.Get("q"), " \t")
+	limit := int64(10)
+	if v := query.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > 100 {
+			handler.SendBadRequest("invalid limit")
+			return
+		}
 	}
-	defer handler.SqliteRollbackTransaction()
 
-	// Get data version first (to support If-None-Match check)
-	dataVersion, err := sqliteGetDataVersion(handler)
-	if err != nil {
-		handler.InternalServerError(err)
+	type item struct {
+		Id        int64  `json:"id"`
+		Name      string `json:"name"`
+		purchases int64
+	}
+	type response struct {
+		Items []item `json:"items"`
+	}
+
+	// Nothing typed yet, nothing to suggest
+	if prefix == "" {
+		handler.SendJsonResponse(http.StatusOK, response{Items: []item{}})
 		return
 	}
 
-	// Check If-None-Match header; if the client's version matches, return 304 Not Modified
-	if handler.request.Header.Get("If-None-Match") == fmt.Sprintf(`"%d"`, dataVersion) {
-		handler.response.WriteHeader(http.StatusNotModified)
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
 		return
 	}
+	defer handler.SqliteRollbackTransaction()
 
-	// Read entire items table
-	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetItems)
+	// Read matching items
+	rows, err := sqliteAutocompleteItems(handler, prefix, limit)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer rows.Close()
-	type item struct {
-		Id     int64  `json:"id"`
-		Name   string `json:"name"`
-		OnList bool   `json:"on_list"`
-	}
 	items := []item{}
 	for rows.Next() {
 		var item item
-		err = rows.Scan(&item.Id, &item.Name, &item.OnList)
+		err = rows.Scan(&item.Id, &item.Name, &item.purchases)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
@@ -360,89 +1076,122 @@ func handleGetItems(handler *Handler) {
 		return
 	}
 
-	// Read entire stores table
-	rows, err = handler.SqliteQuery_ManyRows(queryKeyGetStores)
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	defer rows.Close()
-	type store struct {
-		Id   int64  `json:"id"`
-		Name string `json:"name"`
+
+	// Order equally-purchased items by locale, if set. (Only the items within the limit are reordered, so an item just
+	// past it that would sort earlier isn't swapped in; that's fine for suggestions.)
+	if compare := localeNameCompare(); compare != nil {
+		slices.SortStableFunc(items, func(a, b item) int {
+			if a.purchases != b.purchases {
+				return cmp.Compare(b.purchases, a.purchases)
+			}
+			return compare(a.Name, b.Name)
+		})
 	}
-	stores := []store{}
-	for rows.Next() {
-		var store store
-		err = rows.Scan(&store.Id, &store.Name)
-		if err != nil {
-			handler.InternalServerError(err)
-			return
-		}
-		stores = append(stores, store)
+
+	// Send response
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			Items: items})
+}
+
+// GET /api/check-name?type=item&name=Milk
+//
+// Whether creating an item (or store, with type=store) with the given name would collide with an existing one. The
+// name is validated and trimmed, and looked up, exactly as the create endpoints do, so an invalid name gets the same
+// error they'd give.
+func handleCheckName(handler *Handler) {
+	types := []string{"item", "store"}
+	kind, ok := handler.EnumQueryParam("type", types...)
+	if !ok {
+		return
 	}
-	err = rows.Err()
+	var maxLength int
+	var lookup func(*Handler, string) (*int64, error)
+	switch kind {
+	case "item":
+		maxLength = shoppingMaxItemName
+		lookup = sqliteGetItemIdByName
+	case "store":
+		maxLength = shoppingMaxStoreName
+		lookup = sqliteGetStoreIdByName
+	default:
+		handler.SendInvalidQueryParam("type", types)
+		return
+	}
+	name, ok := handler.ValidateName(handler.request.URL.Query().Get("name"), maxLength)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
+	defer handler.SqliteRollbackTransaction()
 
-	// Read entire sections table
-	rows, err = handler.SqliteQuery_ManyRows(queryKeyGetSections)
+	// Look for an existing item/store with that name
+	existingId, err := lookup(handler, name)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	defer rows.Close()
-	type section struct {
-		Id       int64  `json:"id"`
-		Store    int64  `json:"store"`
-		Position int64  `json:"position"`
-		Name     string `json:"name"`
-	}
-	sections := []section{}
-	for rows.Next() {
-		var section section
-		err = rows.Scan(&section.Id, &section.Store, &section.Position, &section.Name)
-		if err != nil {
-			handler.InternalServerError(err)
-			return
-		}
-		sections = append(sections, section)
-	}
-	err = rows.Err()
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 
-	// Read entire item_stores table
-	rows, err = handler.SqliteQuery_ManyRows(queryKeyGetItemStores)
+	// Send response
+	type response struct {
+		Available  bool   `json:"available"`
+		ExistingId *int64 `json:"existing_id"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			Available:  existingId == nil,
+			ExistingId: existingId})
+}
+
+// GET /api/checksum
+//
+// A hash of the data (see sqliteGetChecksum for exactly what's hashed), so a syncing client can check that its copy
+// really matches, rather than trusting that equal data versions mean equal data. Computed once per data version.
+func handleGetChecksum(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	defer rows.Close()
-	type itemStore struct {
-		Item    int64  `json:"item"`
-		Store   int64  `json:"store"`
-		Sold    bool   `json:"sold"`
-		Section *int64 `json:"section"`
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
 	}
-	itemStores := []itemStore{}
-	for rows.Next() {
-		var itemStore itemStore
-		err = rows.Scan(&itemStore.Item, &itemStore.Store, &itemStore.Sold, &itemStore.Section)
+
+	// Compute checksum, unless we already have for this data version
+	checksum, ok := getCachedChecksum(dataVersion)
+	if !ok {
+		checksum, err = sqliteGetChecksum(handler)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
 		}
-		itemStores = append(itemStores, itemStore)
-	}
-	err = rows.Err()
-	if err != nil {
-		handler.InternalServerError(err)
-		return
+		putCachedChecksum(dataVersion, checksum)
 	}
 
 	// Commit transaction
@@ -454,80 +1203,101 @@ func handleGetItems(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64       `json:"data_version"`
-		Items       []item      `json:"items"`
-		Stores      []store     `json:"stores"`
-		Sections    []section   `json:"sections"`
-		ItemStores  []itemStore `json:"item_stores"`
+		DataVersion int64  `json:"data_version"`
+		Checksum    string `json:"checksum"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
 			DataVersion: dataVersion,
-			Items:       items,
-			Stores:      stores,
-			Sections:    sections,
-			ItemStores:  itemStores})
+			Checksum:    checksum})
 }
 
-// POST /api/create-item
+// GET /api/debug/queries
 //
-// Create a new item, and optionally, record it as being sold in a specific store.
-func handleCreateItem(handler *Handler) {
-	var requestBody struct {
-		Name   string `json:"name"`
-		OnList bool   `json:"on_list"`
-		Store  *int64 `json:"store"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
+// Every prepared query's SQL, with how many times it has run and the total time spent running it. Only exists when
+// SHOPPING_DEBUG_QUERIES=1, since it exposes SQL.
+func handleDebugQueries(handler *Handler) {
+	type query struct {
+		Key     queryKey `json:"key"`
+		Sql     string   `json:"sql"`
+		Count   int64    `json:"count"`
+		TotalMs float64  `json:"total_ms"`
+	}
+	theQueries := []query{}
+	queryStatsMutex.Lock()
+	for key, sql := range queries {
+		stats := queryStats[key]
+		theQueries = append(
+			theQueries,
+			query{
+				Key:     key,
+				Sql:     sql,
+				Count:   stats.count,
+				TotalMs: float64(stats.duration.Microseconds()) / 1000})
+	}
+	queryStatsMutex.Unlock()
+	slices.SortFunc(theQueries, func(a, b query) int { return cmp.Compare(a.Key, b.Key) })
 
-	name := strings.TrimSpace(requestBody.Name)
-	if name == "" {
-		handler.SendBadRequest("empty name")
-		return
+	// Send response
+	type response struct {
+		Queries []query `json:"queries"`
 	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			Queries: theQueries})
+}
 
+// GET /api/duplicates
+//
+// Groups of items whose names are the same ignoring case (which can exist from before names were checked that way), so
+// they can be cleaned up by renaming or deleting all but one.
+func handleGetDuplicates(handler *Handler) {
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Confirm an item with that name doesn't already exist
-	exists, err := sqliteExistsItemByName(handler, name)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-	if exists {
-		handler.SendConflict()
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// Create item
-	itemId, err := sqliteInsertItem(handler, name, requestBody.OnList)
+	// Read duplicate items, starting a new group whenever the lowercased name changes. (Compare names as lowercased by
+	// SQLite, which grouped them, rather than folding them in Go, which folds more than ASCII.)
+	rows, err := sqliteGetDuplicateItems(handler)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Possibly record new item as sold in a store
-	if requestBody.Store != nil {
-		_, err := sqliteUpsertItemStore(handler, itemId, *requestBody.Store, true, nil)
+	defer rows.Close()
+	type item struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	groups := [][]item{}
+	var groupName string
+	for rows.Next() {
+		var duplicate item
+		var lowerName string
+		err = rows.Scan(&duplicate.Id, &duplicate.Name, &lowerName)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
 		}
+		if len(groups) > 0 && lowerName == groupName {
+			groups[len(groups)-1] = append(groups[len(groups)-1], duplicate)
+		} else {
+			groups = append(groups, []item{duplicate})
+			groupName = lowerName
+		}
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -542,50 +1312,36 @@ func handleCreateItem(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
-		Id          int64 `json:"id"`
+		DataVersion int64    `json:"data_version"`
+		Groups      [][]item `json:"groups"`
 	}
 	handler.SendJsonResponse(
-		http.StatusCreated,
+		http.StatusOK,
 		response{
 			DataVersion: dataVersion,
-			Id:          itemId})
+			Groups:      groups})
 }
 
-// POST /api/create-section
-func handleCreateSection(handler *Handler) {
-	var requestBody struct {
-		Store int64  `json:"store"`
-		Name  string `json:"name"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
-	var name string = strings.TrimSpace(requestBody.Name)
-	if name == "" {
-		handler.SendBadRequest("empty name")
-		return
-	}
+// GET /api/events
+//
+// A Server-Sent Events stream of the data version: the current one right away, then each new one once it's committed,
+// so clients can refetch only when something actually changed, instead of polling. Each event is a line like
+// data: {"data_version": 42}. A client that falls behind only gets the latest version.
+func handleGetEvents(handler *Handler) {
+	// Subscribe first, so that no change can slip in between reading the data version and subscribing
+	dataVersions := subscribeToDataVersions()
+	defer unsubscribeFromDataVersions(dataVersions)
 
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Create section
-	id, position, err := sqliteInsertSection(handler, requestBody.Store, name)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	// Get data version
+	dataVersion, err := sqliteGetDataVersion(handler)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -598,76 +1354,66 @@ func handleCreateSection(handler *Handler) {
 		return
 	}
 
-	// Send response
-	type response struct {
-		DataVersion int64 `json:"data_version"`
-		Id          int64 `json:"id"`
-		Position    int64 `json:"position"`
+	// Send events until the client goes away
+	controller := http.NewResponseController(handler.response)
+	handler.response.Header().Set("Content-Type", "text/event-stream")
+	handler.response.Header().Set("Cache-Control", "no-cache")
+	handler.response.WriteHeader(http.StatusOK)
+	for {
+		_, err = fmt.Fprintf(handler.response, "data: {\"data_version\": %d}\n\n", dataVersion)
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case dataVersion = <-dataVersions:
+		case <-handler.request.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		}
 	}
-	handler.SendJsonResponse(
-		http.StatusCreated,
-		response{
-			DataVersion: dataVersion,
-			Id:          id,
-			Position:    position})
 }
 
-// POST /api/create-store
+// GET /api/item/{id}
 //
-// Create a new store, and optionally, record it as selling a specific item.
-func handleCreateStore(handler *Handler) {
-	var requestBody struct {
-		Name string `json:"name"`
-		Item *int64 `json:"item"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
-	var name string = strings.TrimSpace(requestBody.Name)
-	if name == "" {
-		handler.SendBadRequest("empty name")
+// One item, with its item_stores rows, for the item page. 404 if there's no such item.
+func handleGetItem(handler *Handler) {
+	itemId, err := strconv.ParseInt(handler.request.PathValue("id"), 10, 64)
+	if err != nil {
+		handler.SendBadRequest("invalid id")
 		return
 	}
 
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err = handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Confirm a store with that name doesn't already exist
-	exists, err := sqliteExistsStoreByName(handler, name)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-	if exists {
-		handler.SendConflict()
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// Create store
-	storeId, err := sqliteInsertStore(handler, name)
+	// Get the item
+	item, err := sqliteGetItem(handler, itemId)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Possibly record new store as selling an item
-	if requestBody.Item != nil {
-		_, err := sqliteUpsertItemStore(handler, *requestBody.Item, storeId, true, nil)
-		if err != nil {
-			handler.InternalServerError(err)
-			return
-		}
+	if item == nil {
+		handler.SendNotFound()
+		return
 	}
 
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	// Get its stores
+	itemStores, err := sqliteGetItemStoresByItem(handler, itemId)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -682,327 +1428,491 @@ func handleCreateStore(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
-		Id          int64 `json:"id"`
+		DataVersion int64          `json:"data_version"`
+		Item        itemRow        `json:"item"`
+		ItemStores  []itemStoreRow `json:"item_stores"`
 	}
 	handler.SendJsonResponse(
-		http.StatusCreated,
+		http.StatusOK,
 		response{
 			DataVersion: dataVersion,
-			Id:          storeId})
+			Item:        *item,
+			ItemStores:  itemStores})
 }
 
-// POST /api/delete-item
-func handleDeleteItem(handler *Handler) {
-	var requestBody struct {
-		Id int64 `json:"id"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
+// GET /api/items
+// GET /api/items?has_note=1
+// GET /api/items?store=3&section=5
+// GET /api/items?fields=id,name
+// GET /api/items?include=store_count
+// GET /api/items?since=42
+//
+// Items can be filtered with these optional query parameters (stores, sections, and item_stores are not filtered):
+//
+//   - has_note: only items with (true) or without (false) a note
+//   - store: only items sold at the store
+//   - section: only items sold at the store and filed in this section of it (requires store). 404 if there's no such
+//     section, and 400 if it belongs to a different store
+//
+// With fields (e.g. fields=id,name), each item only has the listed fields, to save bandwidth. With include=store_count,
+// each item also has the number of stores that sell it (regardless of fields).
+//
+// With since (a data version the client already has), only rows changed since then are returned, along with
+// deleted_items, deleted_stores, and deleted_sections (ids) and deleted_item_stores ([item, store] pairs) for rows
+// deleted since then. An item_stores row is also returned if its section changed, so section_position stays current.
+// since can't be combined with the other parameters. 410 if the changes since then are no longer known (e.g. the data
+// was restored from an older copy), in which case the client should fetch everything again.
+func handleGetItems(handler *Handler) {
+	// Parse filters. Each filter that isn't given is nil, and matches everything.
+	hasNote, ok := handler.BoolQueryParam("has_note")
+	if !ok {
 		return
 	}
-
-	// Begin transaction
-	err := handler.SqliteBeginTransaction()
-	if err != nil {
-		handler.InternalServerError(err)
+	storeId, ok := handler.Int64QueryParam("store")
+	if !ok {
 		return
 	}
-	defer handler.SqliteRollbackTransaction()
-
-	// Delete item
-	result, err := sqliteDeleteItem(handler, requestBody.Id)
-	if err != nil {
-		handler.InternalServerError(err)
+	sectionId, ok := handler.Int64QueryParam("section")
+	if !ok {
 		return
 	}
-
-	// If nothing was deleted, 409
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		handler.SendConflict()
+	if sectionId != nil && storeId == nil {
+		handler.SendBadRequest("section requires store")
 		return
 	}
 
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
-	if err != nil {
-		handler.InternalServerError(err)
+	// Parse include
+	include, ok := handler.EnumQueryParam("include", "store_count")
+	if !ok {
 		return
 	}
 
-	// Commit transaction
-	err = handler.SqliteCommitTransaction()
-	if err != nil {
-		handler.InternalServerError(err)
-		return
+	// Parse fields. An included field is always returned, so it's as if it were listed too.
+	itemFields := []string{
+		"id", "name", "on_list", "low_stock", "have", "quantity", "unit", "note", "created_at", "updated_at"}
+	if include != "" {
+		itemFields = append(itemFields, include)
 	}
-
-	// Send response
-	type response struct {
-		DataVersion int64 `json:"data_version"`
+	var fields []string
+	if v := handler.request.URL.Query().Get("fields"); v != "" {
+		for field := range strings.SplitSeq(v, ",") {
+			field = strings.TrimSpace(field)
+			if !slices.Contains(itemFields, field) {
+				handler.SendInvalidQueryParam("fields", itemFields)
+				return
+			}
+			fields = append(fields, field)
+		}
+		if include != "" && !slices.Contains(fields, include) {
+			fields = append(fields, include)
+		}
 	}
-	handler.SendJsonResponse(
-		http.StatusOK,
-		response{
-			DataVersion: dataVersion})
-}
 
-// POST /api/delete-section
-func handleDeleteSection(handler *Handler) {
-	var requestBody struct {
-		Id int64 `json:"id"`
-	}
+	filtered := hasNote != nil || storeId != nil || fields != nil || include != ""
 
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
+	// Parse since
+	since, ok := handler.Int64QueryParam("since")
+	if !ok {
+		return
+	}
+	if since != nil && filtered {
+		handler.SendBadRequest("since can't be combined with other parameters")
 		return
 	}
 
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Delete section
-	result, err := sqliteDeleteSection(handler, requestBody.Id)
-	if err != nil {
-		handler.InternalServerError(err)
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// If nothing was deleted, 409
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		handler.SendConflict()
-		return
+	// If syncing incrementally, the client's version must be one we know the changes since (else 410)
+	if since != nil {
+		syncFloor, err := sqliteGetSyncFloor(handler)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if *since < syncFloor || *since > dataVersion {
+			handler.SendResyncRequired("since is unknown; use GET /api/items")
+			return
+		}
 	}
 
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
+	// If filtering by section, it must exist (else 404), and belong to the store (else 400)
+	if sectionId != nil {
+		sectionStore, err := sqliteGetSectionStore(handler, *sectionId)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if sectionStore == nil {
+			handler.SendNotFound()
+			return
+		}
+		if *sectionStore != *storeId {
+			handler.SendBadRequest("section not in store")
+			return
+		}
 	}
 
-	// Commit transaction
-	err = handler.SqliteCommitTransaction()
+	// If we've already built the (unfiltered) response for this data version, just send it again
+	var cached *itemsDumpCacheEntry
+	if !filtered && since == nil {
+		cached = getCachedItemsDump(dataVersion)
+		if cached != nil {
+			handler.SendJsonBytes(http.StatusOK, cached.json, cached.gzippedJson)
+			return
+		}
+	}
+
+	// If reading everything, size the slices up front: growing them row by row is a good part of the time spent on a
+	// big catalog (with 10,000 items, about a quarter of it).
+	var counts tableCounts
+	if !filtered && since == nil {
+		counts, err = sqliteGetTableCounts(handler)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Read items table
+	var rows *sql.Rows
+	if filtered {
+		rows, err = sqliteGetItemsFiltered(handler, hasNote, storeId, sectionId)
+	} else if since != nil {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetItemsSince, *since)
+	} else {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetItems)
+	}
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Send response
-	type response struct {
-		DataVersion int64 `json:"data_version"`
+	defer rows.Close()
+	type item struct {
+		Id         int64    `json:"id"`
+		Name       string   `json:"name"`
+		OnList     bool     `json:"on_list"`
+		LowStock   bool     `json:"low_stock"`
+		Have       bool     `json:"have"`
+		Quantity   *float64 `json:"quantity"`
+		Unit       *string  `json:"unit"`
+		Note       *string  `json:"note"`
+		CreatedAt  int64    `json:"created_at"`
+		UpdatedAt  int64    `json:"updated_at"`
+		StoreCount *int64   `json:"store_count,omitempty"` // Only with include=store_count
+	}
+	items := make([]item, 0, counts.Items)
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name, &item.OnList, &item.LowStock, &item.Have, &item.Quantity, &item.Unit, &item.Note, &item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
 	}
-	handler.SendJsonResponse(
-		http.StatusOK,
-		response{
-			DataVersion: dataVersion})
-}
 
-// POST /api/delete-store
-func handleDeleteStore(handler *Handler) {
-	var requestBody struct {
-		Id int64 `json:"id"`
+	// Order items by name, by locale, if set
+	if compare := localeNameCompare(); compare != nil {
+		slices.SortStableFunc(items, func(a, b item) int { return compare(a.Name, b.Name) })
 	}
 
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
+	// Count the stores that sell each item
+	if include == "store_count" {
+		storeCounts, err := sqliteGetItemStoreCounts(handler)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		for i := range items {
+			storeCount := storeCounts[items[i].Id]
+			items[i].StoreCount = &storeCount
+		}
 	}
 
-	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	// Read entire stores table (or just what changed)
+	if since != nil {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetStoresSince, *since)
+	} else {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetStores)
+	}
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	defer handler.SqliteRollbackTransaction()
-
-	// Delete store
-	result, err := sqliteDeleteStore(handler, requestBody.Id)
+	defer rows.Close()
+	type store struct {
+		Id          int64    `json:"id"`
+		Name        string   `json:"name"`
+		CreatedAt   int64    `json:"created_at"`
+		UpdatedAt   int64    `json:"updated_at"`
+		TaxRate     *float64 `json:"tax_rate"`
+		LoyaltyNote *string  `json:"loyalty_note"`
+	}
+	stores := make([]store, 0, counts.Stores)
+	for rows.Next() {
+		var store store
+		err = rows.Scan(&store.Id, &store.Name, &store.CreatedAt, &store.UpdatedAt, &store.TaxRate, &store.LoyaltyNote)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		stores = append(stores, store)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 
-	// If nothing was deleted, 409
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		handler.SendConflict()
-		return
+	// Read entire sections table (or just what changed)
+	if since != nil {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetSectionsSince, *since)
+	} else {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetSections)
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Commit transaction
-	err = handler.SqliteCommitTransaction()
+	defer rows.Close()
+	type section struct {
+		Id        int64   `json:"id"`
+		Store     int64   `json:"store"`
+		Position  int64   `json:"position"`
+		Name      string  `json:"name"`
+		CreatedAt int64   `json:"created_at"`
+		UpdatedAt int64   `json:"updated_at"`
+		Aisle     *string `json:"aisle"`
+	}
+	sections := make([]section, 0, counts.Sections)
+	for rows.Next() {
+		var section section
+		err = rows.Scan(&section.Id, &section.Store, &section.Position, &section.Name, &section.CreatedAt, &section.UpdatedAt, &section.Aisle)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		sections = append(sections, section)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 
-	// Send response
-	type response struct {
-		DataVersion int64 `json:"data_version"`
-	}
-	handler.SendJsonResponse(
-		http.StatusOK,
-		response{
-			DataVersion: dataVersion})
-}
-
-// POST /api/item-in-store
-//
-// Record that an item is sold at a store, and optionally, which section within the store.
-func handleItemInStore(handler *Handler) {
-	var requestBody struct {
-		Item    int64  `json:"item"`
-		Store   int64  `json:"store"`
-		Section *int64 `json:"section"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
+	// Read entire item_stores table (or just what changed), along with each row's section position (so clients can sort
+	// without a lookup)
+	if since != nil {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetItemStoresSince, *since)
+	} else {
+		rows, err = handler.SqliteQuery_ManyRows(queryKeyGetItemStores)
 	}
-
-	// Begin transaction
-	err := handler.SqliteBeginTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	defer handler.SqliteRollbackTransaction()
-
-	// Confirm the item/store/section all exist, and that the store/section correspond.
-	itemExists, err := sqliteExistsItemById(handler, requestBody.Item)
+	defer rows.Close()
+	type itemStore struct {
+		Item            int64  `json:"item"`
+		Store           int64  `json:"store"`
+		Sold            bool   `json:"sold"`
+		Section         *int64 `json:"section"`
+		SectionPosition *int64 `json:"section_position"`
+		OrderIndex      int64  `json:"order_index"`
+		PriceCents      *int64 `json:"price_cents"`
+	}
+	itemStores := make([]itemStore, 0, counts.ItemStores)
+	for rows.Next() {
+		var itemStore itemStore
+		err = rows.Scan(&itemStore.Item, &itemStore.Store, &itemStore.Sold, &itemStore.Section, &itemStore.SectionPosition, &itemStore.OrderIndex, &itemStore.PriceCents)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		itemStores = append(itemStores, itemStore)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	if !itemExists {
-		handler.SendConflict()
-		return
-	}
-	if requestBody.Section == nil {
-		storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+
+	// Read what was deleted since
+	var deletedItems, deletedStores, deletedSections []int64
+	var deletedItemStores [][2]int64
+	if since != nil {
+		deletedItems, err = sqliteGetDeletedItemsSince(handler, *since)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
 		}
-		if !storeExists {
-			handler.SendConflict()
+		deletedStores, err = sqliteGetDeletedStoresSince(handler, *since)
+		if err != nil {
+			handler.InternalServerError(err)
 			return
 		}
-	} else {
-		storeSectionExists, err := sqliteExistsSectionByStoreIdSectionId(
-			handler,
-			requestBody.Store,
-			*requestBody.Section)
+		deletedSections, err = sqliteGetDeletedSectionsSince(handler, *since)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
 		}
-		if !storeSectionExists {
-			handler.SendConflict()
+		deletedItemStores, err = sqliteGetDeletedItemStoresSince(handler, *since)
+		if err != nil {
+			handler.InternalServerError(err)
 			return
 		}
 	}
 
-	// Upsert the item_store row
-	_, err = sqliteUpsertItemStore(handler, requestBody.Item, requestBody.Store, true, requestBody.Section)
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	// Serialize response, cache it (unless filtered or incremental), and send it
+	type response struct {
+		DataVersion       int64       `json:"data_version"`
+		Items             any         `json:"items"`
+		Stores            []store     `json:"stores"`
+		Sections          []section   `json:"sections"`
+		ItemStores        []itemStore `json:"item_stores"`
+		DeletedItems      []int64     `json:"deleted_items,omitzero"`       // Only with since
+		DeletedStores     []int64     `json:"deleted_stores,omitzero"`      // Only with since
+		DeletedSections   []int64     `json:"deleted_sections,omitzero"`    // Only with since
+		DeletedItemStores [][2]int64  `json:"deleted_item_stores,omitzero"` // Only with since
+	}
+	theResponse := response{
+		DataVersion: dataVersion,
+		Items:       items,
+		Stores:      stores,
+		Sections:    sections,
+		ItemStores:  itemStores}
+	if since != nil {
+		theResponse.DeletedItems = deletedItems
+		theResponse.DeletedStores = deletedStores
+		theResponse.DeletedSections = deletedSections
+		theResponse.DeletedItemStores = deletedItemStores
+		handler.SendJsonResponse(http.StatusOK, theResponse)
+		return
+	}
+	if fields != nil {
+		// Round-trip the items through JSON objects, and drop the fields that weren't asked for
+		itemsJson, err := json.Marshal(items)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		var sparseItems []map[string]json.RawMessage
+		err = json.Unmarshal(itemsJson, &sparseItems)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		for _, sparseItem := range sparseItems {
+			for field := range sparseItem {
+				if !slices.Contains(fields, field) {
+					delete(sparseItem, field)
+				}
+			}
+		}
+		theResponse.Items = sparseItems
+	}
+	if filtered {
+		handler.SendJsonResponse(http.StatusOK, theResponse)
+		return
+	}
+	responseJson, err := json.Marshal(theResponse)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Commit transaction
-	err = handler.SqliteCommitTransaction()
+	cached, err = putCachedItemsDump(dataVersion, append(responseJson, '\n'))
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Send response
-	type response struct {
-		DataVersion int64 `json:"data_version"`
-	}
-	handler.SendJsonResponse(
-		http.StatusOK,
-		response{
-			DataVersion: dataVersion})
+	handler.SendJsonBytes(http.StatusOK, cached.json, cached.gzippedJson)
 }
 
-// POST /api/item-not-in-store
+// GET /api/list/coverage-gaps?store=3
 //
-// Record that an item is not sold at a store.
-func handleItemNotInStore(handler *Handler) {
-	var requestBody struct {
-		Item  int64 `json:"item"`
-		Store int64 `json:"store"`
+// Items on the shopping list that the store doesn't sell, i.e. what would still be needed from elsewhere after shopping
+// there. 404 if there's no such store.
+func handleGetListCoverageGaps(handler *Handler) {
+	storeId, ok := handler.Int64QueryParam("store")
+	if !ok {
+		return
 	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
+	if storeId == nil {
+		handler.SendBadRequest("missing store")
 		return
 	}
 
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Confirm the item and store exist.
-	itemExists, err := sqliteExistsItemById(handler, requestBody.Item)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-	if !itemExists {
-		handler.SendConflict()
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
-	storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+
+	// Confirm the store exists
+	storeExists, err := sqliteExistsStoreById(handler, *storeId)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	if !storeExists {
-		handler.SendConflict()
+		handler.SendNotFound()
 		return
 	}
 
-	// Upsert the item_store row
-	_, err = sqliteUpsertItemStore(handler, requestBody.Item, requestBody.Store, false, nil)
+	// Read items on the list that the store doesn't sell
+	rows, err := sqliteGetListItemsNotSoldAtStore(handler, *storeId)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	defer rows.Close()
+	type item struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1017,50 +1927,64 @@ func handleItemNotInStore(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
+		DataVersion int64  `json:"data_version"`
+		Store       int64  `json:"store"`
+		Items       []item `json:"items"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
-			DataVersion: dataVersion})
+			DataVersion: dataVersion,
+			Store:       *storeId,
+			Items:       items})
 }
 
-// POST /api/item-off
+// GET /api/list/export
 //
-// Move an existing item off the shopping list.
-func handleItemOff(handler *Handler) {
-	// Decode request body
-	var requestBody struct {
-		Item int64 `json:"item"`
-	}
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
-
+// Just the shopping list, alphabetically, with each item's quantity, unit, and note (and nothing about stores), for
+// sending to someone else. JSON by default, or CSV (with a header row) if the Accept header asks for text/csv.
+func handleGetListExport(handler *Handler) {
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Move item off shopping list
-	result, err := sqliteItemOffList(handler, requestBody.Item)
-	if err != nil {
-		handler.InternalServerError(err)
+	// Get data version first, and if the client already has it, 304 Not Modified. Either format has the same version,
+	// but caches must still keep them apart.
+	handler.response.Header().Add("Vary", "Accept")
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// If no rows affected (item doesn't exist), 409
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		handler.SendConflict()
+	// Read items on the list
+	rows, err := sqliteGetOnListItems(handler)
+	if err != nil {
+		handler.InternalServerError(err)
 		return
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	defer rows.Close()
+	type item struct {
+		Id       int64    `json:"id"`
+		Name     string   `json:"name"`
+		Quantity *float64 `json:"quantity"`
+		Unit     *string  `json:"unit"`
+		Note     *string  `json:"note"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name, &item.Quantity, &item.Unit, &item.Note)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1074,51 +1998,78 @@ func handleItemOff(handler *Handler) {
 	}
 
 	// Send response
+	if acceptsCsv(handler.request) {
+		handler.response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		handler.response.WriteHeader(http.StatusOK)
+		writer := csv.NewWriter(handler.response)
+		writer.Write([]string{"name", "quantity", "unit", "note"})
+		for _, item := range items {
+			var quantity, unit, note string
+			if item.Quantity != nil {
+				quantity = strconv.FormatFloat(*item.Quantity, 'f', -1, 64)
+			}
+			if item.Unit != nil {
+				unit = *item.Unit
+			}
+			if item.Note != nil {
+				note = *item.Note
+			}
+			writer.Write([]string{item.Name, quantity, unit, note})
+		}
+		writer.Flush()
+		return
+	}
 	type response struct {
-		DataVersion int64 `json:"data_version"`
+		DataVersion int64  `json:"data_version"`
+		Items       []item `json:"items"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
-			DataVersion: dataVersion})
+			DataVersion: dataVersion,
+			Items:       items})
 }
 
-// POST /api/item-on
+// GET /api/list/orphans
 //
-// Move an existing item on the shopping list.
-func handleItemOn(handler *Handler) {
-	// Decode request body
-	var requestBody struct {
-		Item int64 `json:"item"`
-	}
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
-
+// Items on the shopping list that aren't sold at any store, and so wouldn't show up when shopping at any of them.
+func handleGetListOrphans(handler *Handler) {
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Move item on shopping list
-	result, err := sqliteItemOnList(handler, requestBody.Item)
-	if err != nil {
-		handler.InternalServerError(err)
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// If no rows affected (item doesn't exist), 409
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		handler.SendConflict()
+	// Read orphaned items
+	rows, err := sqliteGetOrphanListItems(handler)
+	if err != nil {
+		handler.InternalServerError(err)
 		return
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	defer rows.Close()
+	type item struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1133,60 +2084,58 @@ func handleItemOn(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
+		DataVersion int64  `json:"data_version"`
+		Items       []item `json:"items"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
-			DataVersion: dataVersion})
+			DataVersion: dataVersion,
+			Items:       items})
 }
 
-// POST /api/rename-item
-func handleRenameItem(handler *Handler) {
-	var requestBody struct {
-		Id   int64  `json:"id"`
-		Name string `json:"name"`
+// GET /api/items/changed-since?t=1760000000
+//
+// Items created or updated after unix time t, oldest change first, for clients that sync by wall-clock time rather than
+// data version. Only changes to the item itself count (not, say, which stores sell it), and deleted items aren't
+// reported at all (they're really deleted), so such clients should still do a full GET /api/items now and then.
+//
+// "as_of" in the response is the t to send next time. It's a second behind the server's clock, since timestamps are
+// whole seconds, so an item can show up twice, but is never missed.
+//
+// t can be at most 30 days ago; anything older is 410 Gone, and the client should do a full GET /api/items instead.
+func handleGetItemsChangedSince(handler *Handler) {
+	const maxAge = 30 * 24 * time.Hour
+	since, ok := handler.Int64QueryParam("t")
+	if !ok {
+		return
 	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
+	if since == nil {
+		handler.SendBadRequest("missing t")
 		return
 	}
-	var name string = strings.TrimSpace(requestBody.Name)
-	if name == "" {
-		handler.SendBadRequest("empty name")
+	now := handler.now()
+	if *since < now.Add(-maxAge).Unix() {
+		handler.SendResyncRequired("t is too long ago; use GET /api/items")
 		return
 	}
 
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Get whether an item already exists with the requested name. If it does, 409. (Note that this also 409s in the
-	// case that the item itself has this name - that's okay).
-	exists, err := sqliteExistsItemByName(handler, name)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-	if exists {
-		handler.SendConflict()
-		return
-	}
-
-	// Update this item's name to the requested name
-	_, err = sqliteUpdateItemName(handler, name, requestBody.Id)
-	if err != nil {
-		handler.InternalServerError(err)
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	// Read changed items
+	items, err := sqliteGetItemsChangedSince(handler, *since)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1201,56 +2150,50 @@ func handleRenameItem(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
+		DataVersion int64     `json:"data_version"`
+		AsOf        int64     `json:"as_of"`
+		Items       []itemRow `json:"items"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
-			DataVersion: dataVersion})
+			DataVersion: dataVersion,
+			AsOf:        now.Unix() - 1,
+			Items:       items})
 }
 
-// POST /api/rename-section
-func handleRenameSection(handler *Handler) {
-	var requestBody struct {
-		Id    int64  `json:"id"`
-		Store int64  `json:"store"`
-		Name  string `json:"name"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
-	var name string = strings.TrimSpace(requestBody.Name)
-	if name == "" {
-		handler.SendBadRequest("empty name")
-		return
+// GET /api/items/recent?limit=20
+//
+// The most recently created or updated items, newest first, for a "recently changed" view. Only changes to the item
+// itself count (not, say, which stores sell it), and items not changed since before timestamps were recorded (whose
+// updated_at is 0) are left out. limit defaults to 20, and is at most 100.
+func handleGetRecentItems(handler *Handler) {
+	limit := int64(20)
+	if v := handler.request.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > 100 {
+			handler.SendBadRequest("invalid limit")
+			return
+		}
 	}
 
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Update this section's name to the requested name
-	result, err := sqliteUpdateSectionName(handler, name, requestBody.Id)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-
-	// If section doesn't exist (so no row updated), 409
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		handler.SendConflict()
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	// Read recent items
+	items, err := sqliteGetRecentItems(handler, limit)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1265,60 +2208,57 @@ func handleRenameSection(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
+		DataVersion int64     `json:"data_version"`
+		Items       []itemRow `json:"items"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
-			DataVersion: dataVersion})
+			DataVersion: dataVersion,
+			Items:       items})
 }
 
-// POST /api/rename-store
-func handleRenameStore(handler *Handler) {
-	var requestBody struct {
-		Id   int64  `json:"id"`
-		Name string `json:"name"`
-	}
-
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
-		return
-	}
-	var name string = strings.TrimSpace(requestBody.Name)
-	if name == "" {
-		handler.SendBadRequest("empty name")
-		return
-	}
-
+// GET /api/items/unused
+//
+// Items that aren't on the shopping list and that no store has an opinion about (not even "not sold here"), by name.
+// These are likely dead entries, to be cleaned up with POST /api/delete-item.
+func handleGetUnusedItems(handler *Handler) {
 	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	err := handler.SqliteBeginReadTransaction()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer handler.SqliteRollbackTransaction()
 
-	// Get whether a store already exists with the requested name. If it does, 409. (Note that this also 409s in the
-	// case that the store itself has this name - that's okay).
-	exists, err := sqliteExistsStoreByName(handler, name)
-	if err != nil {
-		handler.InternalServerError(err)
-		return
-	}
-	if exists {
-		handler.SendConflict()
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// Update this store's name to the requested name
-	_, err = sqliteUpdateStoreName(handler, name, requestBody.Id)
+	// Read unused items
+	rows, err := sqliteGetUnusedItems(handler)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	defer rows.Close()
+	type item struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1333,72 +2273,167 @@ func handleRenameStore(handler *Handler) {
 
 	// Send response
 	type response struct {
-		DataVersion int64 `json:"data_version"`
+		DataVersion int64  `json:"data_version"`
+		Items       []item `json:"items"`
 	}
 	handler.SendJsonResponse(
 		http.StatusOK,
 		response{
-			DataVersion: dataVersion})
+			DataVersion: dataVersion,
+			Items:       items})
 }
 
-// POST /api/reorder-sections
-func handleReorderSections(handler *Handler) {
-	var requestBody struct {
-		Store    int64   `json:"store"`
-		Sections []int64 `json:"sections"`
+// GET /api/layouts
+//
+// Every store (by name) with its sections (in order), and how many items each store and section sells, for managing
+// store layouts in bulk. Each store also says how many of its items aren't in any section.
+func handleGetLayouts(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
 	}
+	defer handler.SqliteRollbackTransaction()
 
-	// Decode request body
-	if handler.DecodeJsonRequestBody(&requestBody) {
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
 		return
 	}
 
-	// Begin transaction
-	err := handler.SqliteBeginTransaction()
+	// Read stores, with item counts
+	rows, err := sqliteGetLayoutStores(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type section struct {
+		Id        int64   `json:"id"`
+		Position  int64   `json:"position"`
+		Name      string  `json:"name"`
+		Aisle     *string `json:"aisle"`
+		ItemCount int64   `json:"item_count"`
+	}
+	type store struct {
+		Id                   int64     `json:"id"`
+		Name                 string    `json:"name"`
+		ItemCount            int64     `json:"item_count"`
+		UnsectionedItemCount int64     `json:"unsectioned_item_count"`
+		Sections             []section `json:"sections"`
+	}
+	stores := []store{}
+	storeIndexes := map[int64]int{}
+	for rows.Next() {
+		store := store{Sections: []section{}}
+		err = rows.Scan(&store.Id, &store.Name, &store.ItemCount, &store.UnsectionedItemCount)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		storeIndexes[store.Id] = len(stores)
+		stores = append(stores, store)
+	}
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	defer handler.SqliteRollbackTransaction()
 
-	// Confirm the provided section ids are a permutation of the store's sections
-	rows, err := sqliteGetSectionIdsByStore(handler, requestBody.Store)
+	// Read sections (already in order), with item counts, filing each section under its store
+	rows, err = sqliteGetLayoutSections(handler)
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
 	defer rows.Close()
-	var theSections []int64
 	for rows.Next() {
-		var section int64
-		err = rows.Scan(&section)
+		var section section
+		var storeId int64
+		err = rows.Scan(&section.Id, &storeId, &section.Position, &section.Name, &section.Aisle, &section.ItemCount)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
 		}
-		theSections = append(theSections, section)
+		i := storeIndexes[storeId]
+		stores[i].Sections = append(stores[i].Sections, section)
 	}
 	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
 	}
-	if !slices.Equal(theSections, slices.Sorted(slices.Values(requestBody.Sections))) {
-		handler.SendConflict()
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
 		return
 	}
 
-	// Update all section positions to their position in the list
-	for position, section := range requestBody.Sections {
-		_, err = sqliteUpdateSectionPosition(handler, int64(position), section, requestBody.Store)
+	// Send response
+	type response struct {
+		DataVersion int64   `json:"data_version"`
+		Stores      []store `json:"stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Stores:      stores})
+}
+
+// GET /api/list/store-coverage
+//
+// Each item on the shopping list, with the stores that sell it. Also summarizes which single store sells the most of
+// the list, and a small set of stores that together sell as much of the list as possible (chosen greedily, so not
+// necessarily the smallest such set, but close enough for a shopping list).
+func handleGetListStoreCoverage(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read on-list items, each joined with the stores that sell it (one row per item/store pair)
+	rows, err := sqliteGetOnListItemStores(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type item struct {
+		Id     int64   `json:"id"`
+		Name   string  `json:"name"`
+		Stores []int64 `json:"stores"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var store *int64
+		err = rows.Scan(&id, &name, &store)
 		if err != nil {
 			handler.InternalServerError(err)
 			return
 		}
+		if len(items) == 0 || items[len(items)-1].Id != id {
+			items = append(items, item{Id: id, Name: name, Stores: []int64{}})
+		}
+		if store != nil {
+			items[len(items)-1].Stores = append(items[len(items)-1].Stores, *store)
+		}
 	}
-
-	// Bump data version
-	dataVersion, err := sqliteBumpDataVersion(handler)
+	err = rows.Err()
 	if err != nil {
 		handler.InternalServerError(err)
 		return
@@ -1411,104 +2446,6515 @@ func handleReorderSections(handler *Handler) {
 		return
 	}
 
-	// Send response
-	type response struct {
-		DataVersion int64 `json:"data_version"`
-	}
-	handler.SendJsonResponse(
-		http.StatusOK,
-		response{
-			DataVersion: dataVersion})
+	// Greedily pick the store that sells the most not-yet-covered items, until no store sells any more of them. The
+	// first pick is the best single store.
+	type storeCoverage struct {
+		Store   int64 `json:"store"`
+		Covered int64 `json:"covered"`
+	}
+	uncovered := map[int64]bool{}
+	for _, item := range items {
+		uncovered[item.Id] = true
+	}
+	coveringStores := []storeCoverage{}
+	for {
+		counts := map[int64]int64{}
+		for _, item := range items {
+			if uncovered[item.Id] {
+				for _, store := range item.Stores {
+					counts[store]++
+				}
+			}
+		}
+		best := storeCoverage{}
+		for store, count := range counts {
+			if count > best.Covered || (count == best.Covered && store < best.Store) {
+				best = storeCoverage{Store: store, Covered: count}
+			}
+		}
+		if best.Covered == 0 {
+			break
+		}
+		coveringStores = append(coveringStores, best)
+		for _, item := range items {
+			if slices.Contains(item.Stores, best.Store) {
+				delete(uncovered, item.Id)
+			}
+		}
+	}
+	var bestStore *storeCoverage
+	if len(coveringStores) > 0 {
+		bestStore = &coveringStores[0]
+	}
+	uncoveredItems := []int64{}
+	for _, item := range items {
+		if uncovered[item.Id] {
+			uncoveredItems = append(uncoveredItems, item.Id)
+		}
+	}
+
+	// Send response
+	type response struct {
+		DataVersion    int64           `json:"data_version"`
+		Items          []item          `json:"items"`
+		BestStore      *storeCoverage  `json:"best_store"`
+		CoveringStores []storeCoverage `json:"covering_stores"`
+		Uncovered      []int64         `json:"uncovered"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:    dataVersion,
+			Items:          items,
+			BestStore:      bestStore,
+			CoveringStores: coveringStores,
+			Uncovered:      uncoveredItems})
+}
+
+// GET /api/low-stock
+//
+// List the items flagged as running low.
+func handleGetLowStock(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read low-stock items
+	rows, err := sqliteGetLowStockItems(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type item struct {
+		Id     int64  `json:"id"`
+		Name   string `json:"name"`
+		OnList bool   `json:"on_list"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name, &item.OnList)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64  `json:"data_version"`
+		Items       []item `json:"items"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Items:       items})
+}
+
+// GET /api/needed
+//
+// Items on the shopping list that we don't already have (see POST /api/set-item-have). For someone who never marks
+// anything as had, this is just the shopping list.
+func handleGetNeeded(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read needed items
+	rows, err := sqliteGetNeededItems(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type item struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var item item
+		err = rows.Scan(&item.Id, &item.Name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64  `json:"data_version"`
+		Items       []item `json:"items"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Items:       items})
+}
+
+// GET /api/sections/by-name?name=Dairy
+//
+// The stores (by name) that have a section with the given name, ignoring case, along with that section's id and exact
+// name. Useful for seeing how consistently stores are laid out before copying sections between them.
+func handleGetSectionsByName(handler *Handler) {
+	name := strings.TrimSpace(handler.request.URL.Query().Get("name"))
+	if name == "" {
+		handler.SendBadRequest("empty name")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read matching sections
+	rows, err := sqliteGetSectionsByNameCaseInsensitive(handler, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type store struct {
+		Id          int64  `json:"id"`
+		Name        string `json:"name"`
+		Section     int64  `json:"section"`
+		SectionName string `json:"section_name"`
+	}
+	stores := []store{}
+	for rows.Next() {
+		var store store
+		err = rows.Scan(&store.Id, &store.Name, &store.Section, &store.SectionName)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		stores = append(stores, store)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64   `json:"data_version"`
+		Stores      []store `json:"stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Stores:      stores})
+}
+
+// GET /api/sections/items?name=Dairy
+//
+// Everything filed under a section name (ignoring case), in every store that has such a section, e.g. to check that
+// Milk is in Dairy everywhere. One group per store (by name), each with that store's section and its items (by name).
+func handleGetSectionItems(handler *Handler) {
+	name := strings.TrimSpace(handler.request.URL.Query().Get("name"))
+	if name == "" {
+		handler.SendBadRequest("empty name")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read items in matching sections, starting a new group whenever the section changes
+	rows, err := sqliteGetSectionItemsByNameCaseInsensitive(handler, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type item struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	type group struct {
+		Store       int64  `json:"store"`
+		StoreName   string `json:"store_name"`
+		Section     int64  `json:"section"`
+		SectionName string `json:"section_name"`
+		Items       []item `json:"items"`
+	}
+	groups := []group{}
+	for rows.Next() {
+		var row group
+		var itemId *int64
+		var itemName *string
+		err = rows.Scan(&row.Store, &row.StoreName, &row.Section, &row.SectionName, &itemId, &itemName)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Section != row.Section {
+			row.Items = []item{}
+			groups = append(groups, row)
+		}
+		if itemId != nil {
+			last := &groups[len(groups)-1]
+			last.Items = append(last.Items, item{Id: *itemId, Name: *itemName})
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64   `json:"data_version"`
+		Groups      []group `json:"groups"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Groups:      groups})
+}
+
+// GET /api/store-stats
+//
+// For each store, how many items are sold there, and how many of those have been filed into a section. "completeness"
+// is the fraction of the two (null if the store sells nothing yet).
+func handleGetStoreStats(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Compute per-store stats
+	rows, err := sqliteGetStoreCompleteness(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type storeStats struct {
+		Store        int64    `json:"store"`
+		Sold         int64    `json:"sold"`
+		Sectioned    int64    `json:"sectioned"`
+		Completeness *float64 `json:"completeness"`
+	}
+	stores := []storeStats{}
+	for rows.Next() {
+		var storeStats storeStats
+		err = rows.Scan(&storeStats.Store, &storeStats.Sold, &storeStats.Sectioned, &storeStats.Completeness)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		stores = append(stores, storeStats)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64        `json:"data_version"`
+		Stores      []storeStats `json:"stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Stores:      stores})
+}
+
+// GET /api/store/{id}/health
+//
+// Layout problems with one store, for a "fix my layout" screen: how many of its items aren't in a section, which
+// sections are empty, and whether section positions have gaps or repeats (they should be exactly 0, 1, 2, ...).
+// "completeness" is as in GET /api/store-stats. 404 if there's no such store.
+func handleGetStoreHealth(handler *Handler) {
+	storeId, err := strconv.ParseInt(handler.request.PathValue("id"), 10, 64)
+	if err != nil {
+		handler.SendBadRequest("invalid id")
+		return
+	}
+
+	// Begin transaction
+	err = handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Confirm the store exists
+	store, err := sqliteGetStore(handler, storeId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if store == nil {
+		handler.SendNotFound()
+		return
+	}
+
+	// Count items sold, and how many are sectioned
+	sold, sectioned, err := sqliteGetStoreSoldCounts(handler, storeId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	var completeness *float64
+	if sold > 0 {
+		fraction := float64(sectioned) / float64(sold)
+		completeness = &fraction
+	}
+
+	// Find empty sections
+	emptySections, err := sqliteGetEmptySectionIds(handler, storeId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Check section positions are dense (they come back ordered by position)
+	sections, err := sqliteGetSectionsByStore(handler, storeId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	positionsDense := true
+	for i, section := range sections {
+		if section.Position != int64(i) {
+			positionsDense = false
+			break
+		}
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion    int64    `json:"data_version"`
+		Store          int64    `json:"store"`
+		Sold           int64    `json:"sold"`
+		Unsectioned    int64    `json:"unsectioned"`
+		Sections       int      `json:"sections"`
+		EmptySections  []int64  `json:"empty_sections"`
+		PositionsDense bool     `json:"positions_dense"`
+		Completeness   *float64 `json:"completeness"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:    dataVersion,
+			Store:          storeId,
+			Sold:           sold,
+			Unsectioned:    sold - sectioned,
+			Sections:       len(sections),
+			EmptySections:  emptySections,
+			PositionsDense: positionsDense,
+			Completeness:   completeness})
+}
+
+// GET /api/stores
+// GET /api/stores?sort=recent
+//
+// Every store, each with its sections in order. Same fields as the flat stores and sections arrays of /api/items, just
+// nested.
+//
+// With sort=recent, the most recently shopped stores (by latest purchase or trip) come first, and stores never shopped
+// at come last, by name.
+func handleGetStores(handler *Handler) {
+	sort, ok := handler.EnumQueryParam("sort", "recent")
+	if !ok {
+		return
+	}
+	storesQueryKey := queryKeyGetStores
+	if sort == "recent" {
+		storesQueryKey = queryKeyGetStoresByRecent
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read entire stores table
+	rows, err := handler.SqliteQuery_ManyRows(storesQueryKey)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type section struct {
+		Id        int64   `json:"id"`
+		Store     int64   `json:"store"`
+		Position  int64   `json:"position"`
+		Name      string  `json:"name"`
+		CreatedAt int64   `json:"created_at"`
+		UpdatedAt int64   `json:"updated_at"`
+		Aisle     *string `json:"aisle"`
+	}
+	type store struct {
+		Id          int64     `json:"id"`
+		Name        string    `json:"name"`
+		CreatedAt   int64     `json:"created_at"`
+		UpdatedAt   int64     `json:"updated_at"`
+		TaxRate     *float64  `json:"tax_rate"`
+		LoyaltyNote *string   `json:"loyalty_note"`
+		Sections    []section `json:"sections"`
+	}
+	stores := []store{}
+	storeIndexes := map[int64]int{}
+	for rows.Next() {
+		store := store{Sections: []section{}}
+		err = rows.Scan(&store.Id, &store.Name, &store.CreatedAt, &store.UpdatedAt, &store.TaxRate, &store.LoyaltyNote)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		storeIndexes[store.Id] = len(stores)
+		stores = append(stores, store)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Read entire sections table, filing each section under its store
+	rows, err = handler.SqliteQuery_ManyRows(queryKeyGetSections)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var section section
+		err = rows.Scan(&section.Id, &section.Store, &section.Position, &section.Name, &section.CreatedAt, &section.UpdatedAt, &section.Aisle)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		i := storeIndexes[section.Store]
+		stores[i].Sections = append(stores[i].Sections, section)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	for _, store := range stores {
+		slices.SortFunc(store.Sections, func(a, b section) int {
+			if a.Position != b.Position {
+				return cmp.Compare(a.Position, b.Position)
+			}
+			return cmp.Compare(a.Id, b.Id)
+		})
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64   `json:"data_version"`
+		Stores      []store `json:"stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Stores:      stores})
+}
+
+// GET /api/sync-status
+//
+// The data version, plus the number of rows in each table, so a client can cheaply decide whether (and roughly how
+// much) to sync.
+func handleGetSyncStatus(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Count rows
+	counts, err := sqliteGetTableCounts(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion     int64 `json:"data_version"`
+		ItemsCount      int64 `json:"items_count"`
+		StoresCount     int64 `json:"stores_count"`
+		SectionsCount   int64 `json:"sections_count"`
+		ItemStoresCount int64 `json:"item_stores_count"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:     dataVersion,
+			ItemsCount:      counts.Items,
+			StoresCount:     counts.Stores,
+			SectionsCount:   counts.Sections,
+			ItemStoresCount: counts.ItemStores})
+}
+
+// GET /api/templates
+//
+// The saved templates, by name, each with the names of its items. An item deleted since the template was saved is
+// listed under its old name, with a null id.
+func handleGetTemplates(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	// Read templates (already in order)
+	rows, err := sqliteGetTemplates(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type item struct {
+		Id   *int64 `json:"id"`
+		Name string `json:"name"`
+	}
+	type template struct {
+		Id        int64  `json:"id"`
+		Name      string `json:"name"`
+		CreatedAt int64  `json:"created_at"`
+		Items     []item `json:"items"`
+	}
+	templates := []template{}
+	templateIndexes := map[int64]int{}
+	for rows.Next() {
+		template := template{Items: []item{}}
+		err = rows.Scan(&template.Id, &template.Name, &template.CreatedAt)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		templateIndexes[template.Id] = len(templates)
+		templates = append(templates, template)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Read template items (already in order), filing each item under its template
+	rows, err = sqliteGetTemplateItems(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item item
+		var templateId int64
+		err = rows.Scan(&templateId, &item.Id, &item.Name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		i := templateIndexes[templateId]
+		templates[i].Items = append(templates[i].Items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64      `json:"data_version"`
+		Templates   []template `json:"templates"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Templates:   templates})
+}
+
+// GET /api/trip
+//
+// The shopping trip in progress, if any, with the items bought on it so far.
+func handleGetTrip(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version first, and if the client already has it, 304 Not Modified
+	dataVersion, done := handler.SqliteGetDataVersionOrNotModified()
+	if done {
+		return
+	}
+
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Trip        *trip `json:"trip"`
+	}
+
+	// Read the active trip
+	trip, err := sqliteGetActiveTrip(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if trip == nil {
+		handler.SendJsonResponse(
+			http.StatusOK,
+			response{
+				DataVersion: dataVersion,
+				Trip:        nil})
+		return
+	}
+
+	// Read the items bought on it
+	rows, err := sqliteGetTripItemIds(handler, trip.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item int64
+		err = rows.Scan(&item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		trip.Items = append(trip.Items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Trip:        trip})
+}
+
+// GET /api/units
+//
+// The units that are stored in canonical form, each with the aliases that are stored as it.
+func handleGetUnits(handler *Handler) {
+	type response struct {
+		Units []unit `json:"units"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			Units: units})
+}
+
+// GET /api/version
+//
+// The database's schema version (as of startup), and the newest one this binary knows about, for debugging deploys. If
+// schema_version is greater, an older binary is running against a database upgraded by a newer one.
+func handleGetVersion(handler *Handler) {
+	type response struct {
+		SchemaVersion      int `json:"schema_version"`
+		KnownSchemaVersion int `json:"known_schema_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			SchemaVersion:      schemaVersion,
+			KnownSchemaVersion: knownSchemaVersion})
+}
+
+// GET /api/ws
+//
+// A WebSocket that pushes the data version: the current one right away, then each new one once it's committed, as
+// messages like {"data_version": 42}. Like GET /api/events, for clients that would rather use a WebSocket. Messages
+// from the client are ignored. The server pings every 30 seconds, and drops a client that doesn't answer in time, or
+// that can't take a message within 10 seconds.
+func handleWebSocket(handler *Handler) {
+	const pingInterval = 30 * time.Second
+	const pongTimeout = 60 * time.Second
+	const writeTimeout = 10 * time.Second
+
+	// Subscribe first, so that no change can slip in between reading the data version and subscribing
+	dataVersions := subscribeToDataVersions()
+	defer unsubscribeFromDataVersions(dataVersions)
+
+	// Begin transaction
+	err := handler.SqliteBeginReadTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get data version
+	dataVersion, err := sqliteGetDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Upgrade to a WebSocket (on failure, the upgrader has already responded)
+	conn, err := webSocketUpgrader.Upgrade(handler.response, handler.request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Read (and discard) messages from the client, which is also what handles pongs and closes. Reading fails once the
+	// client goes away, or stops answering pings.
+	conn.SetReadLimit(1024)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Send the data version whenever it changes, and ping in between
+	type message struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	pings := time.NewTicker(pingInterval)
+	defer pings.Stop()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	err = conn.WriteJSON(message{DataVersion: dataVersion})
+	for err == nil {
+		select {
+		case dataVersion = <-dataVersions:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteJSON(message{DataVersion: dataVersion})
+		case <-pings.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		case <-closed:
+			return
+		case <-shuttingDown:
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(writeTimeout))
+			return
+		}
+	}
+}
+
+// Only same-origin pages may connect (the upgrader's default), like any other request from the web app.
+var webSocketUpgrader = websocket.Upgrader{}
+
+// POST /api/apply-template
+//
+// Put every item in a template onto the shopping list. An item deleted since the template was saved is matched to a
+// current item of the same name if there is one; otherwise it is recreated (on the list) if create_missing is set, and
+// skipped if not. Responds with how many existing items weren't already on the list, how many items were created, and
+// the names that were skipped.
+func handleApplyTemplate(handler *Handler) {
+	var requestBody struct {
+		Id            int64 `json:"id"`
+		CreateMissing bool  `json:"create_missing"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the template exists
+	templateExists, err := sqliteExistsTemplateById(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !templateExists {
+		handler.SendNotFound()
+		return
+	}
+
+	// Relink deleted items to current items of the same name
+	now := handler.now().Unix()
+	_, err = sqliteLinkTemplateItemsByName(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Recreate (or skip) the rest
+	names, err := sqliteGetUnlinkedTemplateItemNames(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	created := int64(0)
+	skipped := []string{}
+	for _, name := range names {
+		if !requestBody.CreateMissing {
+			skipped = append(skipped, name)
+			continue
+		}
+		id, err := sqliteInsertItem(handler, name, true, now)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		_, err = sqliteLinkTemplateItem(handler, id, requestBody.Id, name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		created++
+	}
+
+	// Move the template's items on shopping list
+	result, err := sqliteTemplateItemsOnList(handler, now, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	added, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64    `json:"data_version"`
+		Added       int64    `json:"added"`
+		Created     int64    `json:"created"`
+		Skipped     []string `json:"skipped"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Added:       added,
+			Created:     created,
+			Skipped:     skipped})
+}
+
+// POST /api/batch-rename
+//
+// Rename many items in one transaction, with one data version bump. Renames are applied in order, and each new name
+// must not belong to any other item at the time it is applied. If any rename fails, none are applied, and the response
+// is a 409 "rename_failed" error describing the first failure.
+func handleBatchRename(handler *Handler) {
+	type rename struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	var requestBody struct {
+		Items []rename `json:"items"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	renames := make([]rename, len(requestBody.Items))
+	for i, item := range requestBody.Items {
+		name, ok := handler.ValidateName(item.Name, shoppingMaxItemName)
+		if !ok {
+			return
+		}
+		renames[i] = rename{Id: item.Id, Name: name}
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Apply each rename, stopping at the first one that can't be applied
+	type failure struct {
+		Index  int    `json:"index"`
+		Id     int64  `json:"id"`
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+	sendFailure := func(index int, reason string) {
+		handler.SendErrorWithDetails(
+			http.StatusConflict,
+			"rename_failed",
+			reason,
+			failure{
+				Index:  index,
+				Id:     renames[index].Id,
+				Name:   renames[index].Name,
+				Reason: reason})
+	}
+	for i, rename := range renames {
+		existingId, err := sqliteGetItemIdByName(handler, rename.Name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if existingId != nil && *existingId != rename.Id {
+			sendFailure(i, "name taken")
+			return
+		}
+		result, err := sqliteUpdateItemName(handler, rename.Name, handler.now().Unix(), rename.Id)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		affected, _ := result.RowsAffected()
+		if affected == 0 {
+			sendFailure(i, "no such item")
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64    `json:"data_version"`
+		Items       []rename `json:"items"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Items:       renames})
+}
+
+// POST /api/checkout
+//
+// Record that the given items were all bought at a store: each gets a purchase (of the item's quantity, as with POST
+// /api/mark-bought), is moved off the shopping list, and is marked as sold at the store. An item's existing section at
+// the store, if any, is kept. If the store or any item doesn't exist, 409.
+func handleCheckout(handler *Handler) {
+	// Decode request body
+	var requestBody struct {
+		Store int64   `json:"store"`
+		Items []int64 `json:"items"`
+	}
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the store and items all exist.
+	storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !storeExists {
+		handler.SendConflict()
+		return
+	}
+	for _, item := range requestBody.Items {
+		itemExists, err := sqliteExistsItemById(handler, item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !itemExists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Record a purchase of each item, move it off shopping list, and mark it sold at the store
+	now := handler.now().Unix()
+	for _, item := range requestBody.Items {
+		_, _, err = sqliteInsertPurchase(handler, &requestBody.Store, now, nil, item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		_, err = sqliteItemOffList(handler, now, item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		_, err = sqliteUpsertItemStoreSold(handler, item, requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/clear-list
+//
+// Move every item off the shopping list. Responds with how many were on it.
+func handleClearList(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Move every item off shopping list
+	result, err := sqliteClearList(handler, handler.now().Unix())
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	affected, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Affected    int64 `json:"affected"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Affected:    affected})
+}
+
+// POST /api/create-item
+//
+// Create a new item, and optionally, record it as being sold in a specific store (which must exist, else 409), and,
+// optionally, in a specific section of that store (which must belong to it, else 409).
+//
+// If "if_not_exists" is set and an item with that name already exists, respond 200 with the existing item's id rather
+// than 409.
+//
+// "on_list", if given (true or false), decides whether the new item goes on the shopping list. If it's left out, the
+// SHOPPING_DEFAULT_ON_LIST setting decides (off by default).
+//
+// "unit", if given, is the item's unit of measure (see POST /api/set-item-unit).
+func handleCreateItem(handler *Handler) {
+	var requestBody struct {
+		Name        string  `json:"name"`
+		OnList      *bool   `json:"on_list"`
+		Store       *int64  `json:"store"`
+		Section     *int64  `json:"section"`
+		IfNotExists bool    `json:"if_not_exists"`
+		Unit        *string `json:"unit"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	if requestBody.Section != nil && requestBody.Store == nil {
+		handler.SendBadRequest("section without store")
+		return
+	}
+
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxItemName)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Id          int64 `json:"id"`
+	}
+
+	// Confirm an item with that name doesn't already exist
+	existingId, err := sqliteGetItemIdByName(handler, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if existingId != nil {
+		if !requestBody.IfNotExists {
+			handler.SendConflict()
+			return
+		}
+		dataVersion, err := sqliteGetDataVersion(handler)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		handler.SendJsonResponse(
+			http.StatusOK,
+			response{
+				DataVersion: dataVersion,
+				Id:          *existingId})
+		return
+	}
+
+	// Confirm the store exists, and the section (if any) belongs to it
+	if requestBody.Store != nil && requestBody.Section == nil {
+		storeExists, err := sqliteExistsStoreById(handler, *requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !storeExists {
+			handler.SendConflict()
+			return
+		}
+	}
+	if requestBody.Section != nil {
+		storeSectionExists, err := sqliteExistsSectionByStoreIdSectionId(
+			handler,
+			*requestBody.Store,
+			*requestBody.Section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !storeSectionExists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Create item. If the client didn't say whether it goes on the list, the server's default decides.
+	onList := shoppingDefaultOnList
+	if requestBody.OnList != nil {
+		onList = *requestBody.OnList
+	}
+	now := handler.now().Unix()
+	itemId, err := sqliteInsertItem(handler, name, onList, now)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if unit := normalizeUnit(trimToNil(requestBody.Unit)); unit != nil {
+		_, err = sqliteUpdateItemUnit(handler, unit, now, itemId)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Possibly record new item as sold in a store
+	if requestBody.Store != nil {
+		_, err := sqliteUpsertItemStore(handler, itemId, *requestBody.Store, true, requestBody.Section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion: dataVersion,
+			Id:          itemId})
+}
+
+// POST /api/create-items
+//
+// Create many items at once, e.g. when copying out a recipe, with one data version bump. Names that already exist (or
+// appear earlier in the request) are skipped. "on_list" is as in POST /api/create-item, and if "store" is given (it
+// must exist, else 409), every created item is recorded as sold there. Responds with the created items' ids, and the
+// skipped names.
+func handleCreateItems(handler *Handler) {
+	var requestBody struct {
+		Names  []string `json:"names"`
+		OnList *bool    `json:"on_list"`
+		Store  *int64   `json:"store"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	names := make([]string, len(requestBody.Names))
+	for i, name := range requestBody.Names {
+		name, ok := handler.ValidateName(name, shoppingMaxItemName)
+		if !ok {
+			return
+		}
+		names[i] = name
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the store exists
+	if requestBody.Store != nil {
+		storeExists, err := sqliteExistsStoreById(handler, *requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !storeExists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Create each item that doesn't already exist (or appear earlier in the request), possibly recording it as sold in
+	// the store
+	onList := shoppingDefaultOnList
+	if requestBody.OnList != nil {
+		onList = *requestBody.OnList
+	}
+	now := handler.now().Unix()
+	type createdItem struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	created := []createdItem{}
+	skipped := []string{}
+	for _, name := range names {
+		exists, err := sqliteExistsItemByName(handler, name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if exists {
+			skipped = append(skipped, name)
+			continue
+		}
+		itemId, err := sqliteInsertItem(handler, name, onList, now)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if requestBody.Store != nil {
+			_, err = sqliteUpsertItemStore(handler, itemId, *requestBody.Store, true, nil)
+			if err != nil {
+				handler.InternalServerError(err)
+				return
+			}
+		}
+		created = append(created, createdItem{Id: itemId, Name: name})
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64         `json:"data_version"`
+		Created     []createdItem `json:"created"`
+		Skipped     []string      `json:"skipped"`
+	}
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion: dataVersion,
+			Created:     created,
+			Skipped:     skipped})
+}
+
+// POST /api/create-section
+//
+// "aisle" is an optional label for the section's aisle ("7"), just for display; sections are still ordered by position.
+func handleCreateSection(handler *Handler) {
+	var requestBody struct {
+		Store int64   `json:"store"`
+		Name  string  `json:"name"`
+		Aisle *string `json:"aisle"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxSectionName)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// If the store already has a section with this name (ignoring case), 409 with its id
+	existingId, err := sqliteGetSectionIdByNameCaseInsensitive(handler, requestBody.Store, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if existingId != nil {
+		type details struct {
+			Id int64 `json:"id"`
+		}
+		handler.SendErrorWithDetails(http.StatusConflict, "name_taken", "name taken", details{Id: *existingId})
+		return
+	}
+
+	// Create section
+	id, position, err := sqliteInsertSection(handler, requestBody.Store, name, handler.now().Unix(), trimToNil(requestBody.Aisle))
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Id          int64 `json:"id"`
+		Position    int64 `json:"position"`
+	}
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion: dataVersion,
+			Id:          id,
+			Position:    position})
+}
+
+// POST /api/create-store
+//
+// Create a new store, and optionally, record it as selling a specific item (which must exist, else 409). A sales tax
+// rate (between 0 and 1) and a loyalty account note may be given too; see POST /api/update-store-meta.
+//
+// "sections" optionally lists names of sections to create in the new store, in order (no two the same, ignoring case).
+// The response then includes their ids and positions.
+//
+// If "if_not_exists" is set and a store with that name already exists, respond 200 with the existing store's id rather
+// than 409.
+func handleCreateStore(handler *Handler) {
+	var requestBody struct {
+		Name        string   `json:"name"`
+		Item        *int64   `json:"item"`
+		IfNotExists bool     `json:"if_not_exists"`
+		TaxRate     *float64 `json:"tax_rate"`
+		LoyaltyNote *string  `json:"loyalty_note"`
+		Sections    []string `json:"sections"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxStoreName)
+	if !ok {
+		return
+	}
+	if !validTaxRate(requestBody.TaxRate) {
+		handler.SendBadRequest("invalid tax_rate")
+		return
+	}
+	sectionNames := []string{}
+	seenSectionNames := map[string]bool{}
+	for _, sectionName := range requestBody.Sections {
+		sectionName, ok := handler.ValidateName(sectionName, shoppingMaxSectionName)
+		if !ok {
+			return
+		}
+		if seenSectionNames[lowerAscii(sectionName)] {
+			handler.SendBadRequest("duplicate section name")
+			return
+		}
+		seenSectionNames[lowerAscii(sectionName)] = true
+		sectionNames = append(sectionNames, sectionName)
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	type section struct {
+		Id       int64  `json:"id"`
+		Name     string `json:"name"`
+		Position int64  `json:"position"`
+	}
+	type response struct {
+		DataVersion int64     `json:"data_version"`
+		Id          int64     `json:"id"`
+		Sections    []section `json:"sections,omitempty"`
+	}
+
+	// Confirm a store with that name doesn't already exist
+	existingId, err := sqliteGetStoreIdByName(handler, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if existingId != nil {
+		if !requestBody.IfNotExists {
+			handler.SendConflict()
+			return
+		}
+		dataVersion, err := sqliteGetDataVersion(handler)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		handler.SendJsonResponse(
+			http.StatusOK,
+			response{
+				DataVersion: dataVersion,
+				Id:          *existingId})
+		return
+	}
+
+	// Confirm the item (if any) exists
+	if requestBody.Item != nil {
+		itemExists, err := sqliteExistsItemById(handler, *requestBody.Item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !itemExists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Create store
+	storeId, err := sqliteInsertStore(
+		handler,
+		name,
+		handler.now().Unix(),
+		requestBody.TaxRate,
+		trimToNil(requestBody.LoyaltyNote))
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Create its sections, in order
+	sections := []section{}
+	for _, sectionName := range sectionNames {
+		id, position, err := sqliteInsertSection(handler, storeId, sectionName, handler.now().Unix(), nil)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		sections = append(sections, section{Id: id, Name: sectionName, Position: position})
+	}
+
+	// Possibly record new store as selling an item
+	if requestBody.Item != nil {
+		_, err := sqliteUpsertItemStore(handler, *requestBody.Item, storeId, true, nil)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion: dataVersion,
+			Id:          storeId,
+			Sections:    sections})
+}
+
+// POST /api/delete-item
+//
+// With only_if_off_list, an item that is on the list is not deleted; the response is a 409 "on_list" error with its
+// on_list state, so the client can ask for confirmation and retry without the guard.
+//
+// The response includes the deleted item and its item_stores rows, so the client can offer to undo by recreating them.
+func handleDeleteItem(handler *Handler) {
+	var requestBody struct {
+		Id            int64 `json:"id"`
+		OnlyIfOffList bool  `json:"only_if_off_list"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Read the item and its item_stores rows before they're gone
+	item, err := sqliteGetItem(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	itemStores, err := sqliteGetItemStoresByItem(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If asked to, refuse to delete an item that is on the list
+	if requestBody.OnlyIfOffList && item != nil && item.OnList {
+		type details struct {
+			Id     int64 `json:"id"`
+			OnList bool  `json:"on_list"`
+		}
+		handler.SendErrorWithDetails(
+			http.StatusConflict,
+			"on_list",
+			"item is on the list",
+			details{
+				Id:     item.Id,
+				OnList: item.OnList})
+		return
+	}
+
+	// Delete item
+	result, err := sqliteDeleteItem(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If nothing was deleted, 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64          `json:"data_version"`
+		Item        itemRow        `json:"item"`
+		ItemStores  []itemStoreRow `json:"item_stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Item:        *item,
+			ItemStores:  itemStores})
+}
+
+// POST /api/delete-section
+//
+// The response includes the deleted section and the item_stores rows that were filed in it, so the client can offer to
+// undo by recreating them.
+func handleDeleteSection(handler *Handler) {
+	var requestBody struct {
+		Id int64 `json:"id"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Read the section, and the item_stores rows filed in it, before they're gone (or unfiled)
+	section, err := sqliteGetSection(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	itemStores, err := sqliteGetItemStoresBySection(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Delete section
+	result, err := sqliteDeleteSection(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If nothing was deleted, 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64          `json:"data_version"`
+		Section     sectionRow     `json:"section"`
+		ItemStores  []itemStoreRow `json:"item_stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Section:     *section,
+			ItemStores:  itemStores})
+}
+
+// POST /api/delete-store
+//
+// The response includes the deleted store, its sections, and its item_stores rows, so the client can offer to undo by
+// passing them back to POST /api/restore-store.
+func handleDeleteStore(handler *Handler) {
+	var requestBody struct {
+		Id int64 `json:"id"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Read the store, its sections, and its item_stores rows before they're gone
+	store, err := sqliteGetStore(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	sections, err := sqliteGetSectionsByStore(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	itemStores, err := sqliteGetItemStoresByStore(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Delete store. If something still references it (which can only happen if the cascades were lost somehow), 409.
+	result, err := sqliteDeleteStore(handler, requestBody.Id)
+	if isSqliteForeignKeyError(err) {
+		handler.SendConflictMessage("store is still referenced")
+		return
+	}
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If nothing was deleted, 404
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendNotFound()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64          `json:"data_version"`
+		Store       storeRow       `json:"store"`
+		Sections    []sectionRow   `json:"sections"`
+		ItemStores  []itemStoreRow `json:"item_stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Store:       *store,
+			Sections:    sections,
+			ItemStores:  itemStores})
+}
+
+// POST /api/end-trip
+//
+// End the shopping trip in progress: every item bought on it is recorded as a purchase and moved off the shopping list.
+func handleEndTrip(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get the active trip. If there isn't one, 409
+	tripId, err := sqliteGetActiveTripId(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if tripId == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Record purchases, move bought items off the shopping list, and end the trip
+	now := handler.now().Unix()
+	result, err := sqliteInsertTripPurchases(handler, now, *tripId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	purchased, _ := result.RowsAffected()
+	_, err = sqliteTripItemsOffList(handler, now, *tripId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	_, err = sqliteEndTrip(handler, now, *tripId)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Id          int64 `json:"id"`
+		Purchased   int64 `json:"purchased"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Id:          *tripId,
+			Purchased:   purchased})
+}
+
+// POST /api/import
+//
+// Replace all data with a dump in the shape GET /api/items returns (its data_version is ignored), e.g. to restore one
+// taken earlier. Trips, purchase history, and templates are cleared too, since they refer to the old items and stores.
+//
+// The whole dump is checked before anything is touched (unique ids and names, valid references, and everything the
+// create endpoints would check), and a bad dump is a 400 saying what's wrong with it. The swap then happens in one
+// transaction, so if anything fails partway, none of it happened, and the live data is untouched.
+func handleImport(handler *Handler) {
+	type itemStore struct {
+		Item            int64  `json:"item"`
+		Store           int64  `json:"store"`
+		Sold            bool   `json:"sold"`
+		Section         *int64 `json:"section"`
+		SectionPosition *int64 `json:"section_position"` // Ignored (it's derived)
+		OrderIndex      int64  `json:"order_index"`
+		PriceCents      *int64 `json:"price_cents"`
+	}
+	var requestBody struct {
+		DataVersion int64        `json:"data_version"` // Ignored
+		Items       []itemRow    `json:"items"`
+		Stores      []storeRow   `json:"stores"`
+		Sections    []sectionRow `json:"sections"`
+		ItemStores  []itemStore  `json:"item_stores"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Check items
+	itemNames := map[string]bool{}
+	itemIds := map[int64]bool{}
+	for i, item := range requestBody.Items {
+		name, ok := handler.ValidateName(item.Name, shoppingMaxItemName)
+		if !ok {
+			return
+		}
+		if itemIds[item.Id] {
+			handler.SendBadRequest(fmt.Sprintf("duplicate item id %d", item.Id))
+			return
+		}
+		if itemNames[name] {
+			handler.SendBadRequest(fmt.Sprintf("duplicate item name %q", name))
+			return
+		}
+		if item.Quantity != nil && *item.Quantity <= 0 {
+			handler.SendBadRequest(fmt.Sprintf("invalid quantity for item %d", item.Id))
+			return
+		}
+		itemIds[item.Id] = true
+		itemNames[name] = true
+		requestBody.Items[i].Name = name
+	}
+
+	// Check stores
+	storeNames := map[string]bool{}
+	storeIds := map[int64]bool{}
+	for i, store := range requestBody.Stores {
+		name, ok := handler.ValidateName(store.Name, shoppingMaxStoreName)
+		if !ok {
+			return
+		}
+		if storeIds[store.Id] {
+			handler.SendBadRequest(fmt.Sprintf("duplicate store id %d", store.Id))
+			return
+		}
+		if storeNames[name] {
+			handler.SendBadRequest(fmt.Sprintf("duplicate store name %q", name))
+			return
+		}
+		if !validTaxRate(store.TaxRate) {
+			handler.SendBadRequest(fmt.Sprintf("invalid tax_rate for store %d", store.Id))
+			return
+		}
+		storeIds[store.Id] = true
+		storeNames[name] = true
+		requestBody.Stores[i].Name = name
+	}
+
+	// Check sections
+	type storeSectionName struct {
+		store int64
+		name  string
+	}
+	sectionNames := map[storeSectionName]bool{}
+	sectionStores := map[int64]int64{}
+	for i, section := range requestBody.Sections {
+		name, ok := handler.ValidateName(section.Name, shoppingMaxSectionName)
+		if !ok {
+			return
+		}
+		if _, exists := sectionStores[section.Id]; exists {
+			handler.SendBadRequest(fmt.Sprintf("duplicate section id %d", section.Id))
+			return
+		}
+		if !storeIds[section.Store] {
+			handler.SendBadRequest(fmt.Sprintf("section %d is in unknown store %d", section.Id, section.Store))
+			return
+		}
+		key := storeSectionName{section.Store, lowerAscii(name)}
+		if sectionNames[key] {
+			handler.SendBadRequest(fmt.Sprintf("duplicate section name %q in store %d", name, section.Store))
+			return
+		}
+		sectionStores[section.Id] = section.Store
+		sectionNames[key] = true
+		requestBody.Sections[i].Name = name
+	}
+
+	// Check item_stores
+	type itemStoreKey struct {
+		item  int64
+		store int64
+	}
+	itemStoreKeys := map[itemStoreKey]bool{}
+	for _, itemStore := range requestBody.ItemStores {
+		if !itemIds[itemStore.Item] || !storeIds[itemStore.Store] {
+			handler.SendBadRequest(
+				fmt.Sprintf("item_store (%d, %d) has unknown item or store", itemStore.Item, itemStore.Store))
+			return
+		}
+		key := itemStoreKey{itemStore.Item, itemStore.Store}
+		if itemStoreKeys[key] {
+			handler.SendBadRequest(fmt.Sprintf("duplicate item_store (%d, %d)", itemStore.Item, itemStore.Store))
+			return
+		}
+		if itemStore.Section != nil {
+			store, exists := sectionStores[*itemStore.Section]
+			if !exists || store != itemStore.Store {
+				handler.SendBadRequest(fmt.Sprintf(
+					"item_store (%d, %d) has section %d, which isn't in that store",
+					itemStore.Item,
+					itemStore.Store,
+					*itemStore.Section))
+				return
+			}
+		}
+		if itemStore.PriceCents != nil && *itemStore.PriceCents < 0 {
+			handler.SendBadRequest(
+				fmt.Sprintf("invalid price_cents for item_store (%d, %d)", itemStore.Item, itemStore.Store))
+			return
+		}
+		itemStoreKeys[key] = true
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Delete everything
+	err = sqliteDeleteEverything(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Insert the dump
+	for _, item := range requestBody.Items {
+		_, err = sqliteImportItem(handler, item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+	for _, store := range requestBody.Stores {
+		_, err = sqliteImportStore(handler, store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+	for _, section := range requestBody.Sections {
+		_, err = sqliteImportSection(handler, section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+	for _, itemStore := range requestBody.ItemStores {
+		_, err = sqliteImportItemStore(
+			handler,
+			itemStore.Item,
+			itemStore.Store,
+			itemStore.Sold,
+			itemStore.Section,
+			itemStore.OrderIndex,
+			itemStore.PriceCents)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/import-text
+//
+// Create items from a plain-text body with one item per line: a name, optionally followed by a comma and a quantity
+// with an optional unit ("milk, 2 l"). Blank lines, and names that already exist (or appear earlier in the text), are
+// skipped. If the "on_list" query parameter is true, created items are put on the shopping list.
+func handleImportText(handler *Handler) {
+	onListParam, ok := handler.BoolQueryParam("on_list")
+	if !ok {
+		return
+	}
+	onList := onListParam != nil && *onListParam
+
+	// Read request body
+	text, err := io.ReadAll(handler.request.Body)
+	if err != nil {
+		handler.SendBadRequest(err.Error())
+		return
+	}
+
+	// Begin transaction
+	err = handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Read every existing item name up front, rather than checking each line with its own query (imports can be big)
+	existing, err := sqliteGetItemNames(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Create each item that doesn't already exist (or appear earlier in the text)
+	type createdItem struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	created := []createdItem{}
+	skipped := []string{}
+	lineNumber := 0
+	for line := range strings.Lines(string(text)) {
+		lineNumber++
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		rawName, quantity, unit, ok := parseImportTextLine(line)
+		if !ok {
+			handler.SendBadRequest(fmt.Sprintf("invalid quantity on line %d", lineNumber))
+			return
+		}
+		name, ok := handler.ValidateName(rawName, shoppingMaxItemName)
+		if !ok {
+			return
+		}
+		if existing[name] {
+			skipped = append(skipped, name)
+			continue
+		}
+
+		now := handler.now().Unix()
+		itemId, err := sqliteInsertItem(handler, name, onList, now)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if quantity != nil {
+			_, err = sqliteUpdateItemQuantity(handler, quantity, now, itemId)
+			if err != nil {
+				handler.InternalServerError(err)
+				return
+			}
+		}
+		if unit != nil {
+			_, err = sqliteUpdateItemUnit(handler, unit, now, itemId)
+			if err != nil {
+				handler.InternalServerError(err)
+				return
+			}
+		}
+		existing[name] = true
+		created = append(created, createdItem{Id: itemId, Name: name})
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64         `json:"data_version"`
+		Created     []createdItem `json:"created"`
+		Skipped     []string      `json:"skipped"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Created:     created,
+			Skipped:     skipped})
+}
+
+// Split a POST /api/import-text line into its name, and the quantity and unit after its last comma, if any. If what
+// follows the last comma doesn't start with a number, it's all part of the name ("salt, sea"). ok is false if it starts
+// with something number-like that isn't a positive number.
+func parseImportTextLine(line string) (name string, quantity *float64, unit *string, ok bool) {
+	i := strings.LastIndex(line, ",")
+	if i < 0 {
+		return line, nil, nil, true
+	}
+	rest := strings.TrimSpace(line[i+1:])
+	numberLength := strings.IndexFunc(rest, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if numberLength < 0 {
+		numberLength = len(rest)
+	}
+	if numberLength == 0 {
+		return line, nil, nil, true
+	}
+	n, err := strconv.ParseFloat(rest[:numberLength], 64)
+	if err != nil || n <= 0 {
+		return "", nil, nil, false
+	}
+	unitName := rest[numberLength:]
+	return line[:i], &n, normalizeUnit(trimToNil(&unitName)), true
+}
+
+// POST /api/item-in-store
+//
+// Record that an item is sold at a store, and optionally, which section within the store. If "on_list" is set, also
+// move the item on the shopping list.
+func handleItemInStore(handler *Handler) {
+	var requestBody struct {
+		Item    int64  `json:"item"`
+		Store   int64  `json:"store"`
+		Section *int64 `json:"section"`
+		OnList  bool   `json:"on_list"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the item/store/section all exist, and that the store/section correspond.
+	itemExists, err := sqliteExistsItemById(handler, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !itemExists {
+		handler.SendConflict()
+		return
+	}
+	if requestBody.Section == nil {
+		storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !storeExists {
+			handler.SendConflict()
+			return
+		}
+	} else {
+		storeSectionExists, err := sqliteExistsSectionByStoreIdSectionId(
+			handler,
+			requestBody.Store,
+			*requestBody.Section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !storeSectionExists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Upsert the item_store row
+	_, err = sqliteUpsertItemStore(handler, requestBody.Item, requestBody.Store, true, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Possibly move item on shopping list
+	if requestBody.OnList {
+		_, err = sqliteItemOnList(handler, handler.now().Unix(), requestBody.Item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+	onList, err := sqliteGetItemOnList(handler, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type itemStore struct {
+		Item    int64  `json:"item"`
+		Store   int64  `json:"store"`
+		Sold    bool   `json:"sold"`
+		Section *int64 `json:"section"`
+	}
+	type response struct {
+		DataVersion int64     `json:"data_version"`
+		ItemStore   itemStore `json:"item_store"`
+		OnList      bool      `json:"on_list"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			ItemStore: itemStore{
+				Item:    requestBody.Item,
+				Store:   requestBody.Store,
+				Sold:    true,
+				Section: requestBody.Section},
+			OnList: onList})
+}
+
+// POST /api/item-not-in-store
+//
+// Record that an item is not sold at a store.
+func handleItemNotInStore(handler *Handler) {
+	var requestBody struct {
+		Item  int64 `json:"item"`
+		Store int64 `json:"store"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the item and store exist.
+	itemExists, err := sqliteExistsItemById(handler, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !itemExists {
+		handler.SendConflict()
+		return
+	}
+	storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !storeExists {
+		handler.SendConflict()
+		return
+	}
+
+	// Upsert the item_store row
+	_, err = sqliteUpsertItemStore(handler, requestBody.Item, requestBody.Store, false, nil)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/item-off
+//
+// Move an existing item off the shopping list.
+func handleItemOff(handler *Handler) {
+	// Decode request body
+	var requestBody struct {
+		Item int64 `json:"item"`
+	}
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Move item off shopping list
+	result, err := sqliteItemOffList(handler, handler.now().Unix(), requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If no rows affected (item doesn't exist), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/item-on
+//
+// Move an existing item on the shopping list, optionally setting its quantity, unit, and note at the same time. Omitted
+// fields are left as they are; an empty unit or note clears it. Known units are stored in canonical form (see
+// GET /api/units).
+func handleItemOn(handler *Handler) {
+	// Decode request body
+	var requestBody struct {
+		Item     int64    `json:"item"`
+		Quantity *float64 `json:"quantity"`
+		Unit     *string  `json:"unit"`
+		Note     *string  `json:"note"`
+	}
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	if requestBody.Quantity != nil && *requestBody.Quantity <= 0 {
+		handler.SendBadRequest("invalid quantity")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Move item on shopping list
+	result, err := sqliteItemOnListWithDetails(
+		handler,
+		requestBody.Item,
+		requestBody.Quantity,
+		normalizeUnit(trimToNil(requestBody.Unit)),
+		requestBody.Unit != nil,
+		trimToNil(requestBody.Note),
+		requestBody.Note != nil,
+		handler.now().Unix())
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If no rows affected (item doesn't exist), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Read back the item
+	item, err := sqliteGetItem(handler, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64    `json:"data_version"`
+		Item        *itemRow `json:"item"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Item:        item})
+}
+
+// POST /api/mark-bought
+//
+// Record that an item was bought (optionally, at a store, which must exist, else 409), and move it off the shopping
+// list. The purchase records the quantity given, or if there isn't one, the quantity on the item. If the item doesn't
+// exist, 409. Responds with the purchase's id and quantity.
+func handleMarkBought(handler *Handler) {
+	var requestBody struct {
+		Item     int64    `json:"item"`
+		Store    *int64   `json:"store"`
+		Quantity *float64 `json:"quantity"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	if requestBody.Quantity != nil && *requestBody.Quantity <= 0 {
+		handler.SendBadRequest("invalid quantity")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the store exists
+	if requestBody.Store != nil {
+		storeExists, err := sqliteExistsStoreById(handler, *requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !storeExists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Record purchase. If there's no such item, 409
+	now := handler.now().Unix()
+	purchaseId, quantity, err := sqliteInsertPurchase(handler, requestBody.Store, now, requestBody.Quantity, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if purchaseId == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Move item off shopping list
+	_, err = sqliteItemOffList(handler, now, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64    `json:"data_version"`
+		Purchase    int64    `json:"purchase"`
+		Quantity    *float64 `json:"quantity"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Purchase:    *purchaseId,
+			Quantity:    quantity})
+}
+
+// POST /api/merge-sections
+//
+// Move every item filed in section "from" to section "to", then delete "from". Both must belong to "store".
+func handleMergeSections(handler *Handler) {
+	var requestBody struct {
+		Store int64 `json:"store"`
+		From  int64 `json:"from"`
+		To    int64 `json:"to"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	if requestBody.From == requestBody.To {
+		handler.SendBadRequest("can't merge a section into itself")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm both sections exist in the store
+	for _, section := range []int64{requestBody.From, requestBody.To} {
+		exists, err := sqliteExistsSectionByStoreIdSectionId(handler, requestBody.Store, section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !exists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Move items
+	result, err := sqliteMoveItemStoresToSection(handler, requestBody.Store, requestBody.From, requestBody.To)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	moved, _ := result.RowsAffected()
+
+	// Delete the now-empty section
+	_, err = sqliteDeleteSection(handler, requestBody.From)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Moved       int64 `json:"moved"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Moved:       moved})
+}
+
+// POST /api/move-section
+//
+// Move a section from index "from" to index "to" in its store's order (as in a drag and drop). Unlike
+// /api/reorder-sections, which replaces the whole order and so silently undoes any concurrent reorder, this only
+// touches the one section, and checks that it's still where the client thinks it is:
+//
+//   - If the section is still at "from", the move is applied to the current order, even if other sections have moved
+//     since the client last synced. So two devices moving different sections (that don't shift each other) both win.
+//   - If it isn't (someone else moved it, or moved or added or deleted a section before it), the move is refused with a
+//     412, and the response carries the current order so the client can redo the drag against it.
+func handleMoveSection(handler *Handler) {
+	var requestBody struct {
+		Section int64 `json:"section"`
+		From    int64 `json:"from"`
+		To      int64 `json:"to"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get the section's store. If the section doesn't exist, 409
+	store, err := sqliteGetSectionStore(handler, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if store == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// If the section isn't where the client thinks it is, 412 with the current order
+	sectionPositions, err := sqliteGetSectionPositionsByStore(handler, *store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	order := []int64{}
+	for _, sectionPosition := range sectionPositions {
+		order = append(order, sectionPosition.Id)
+	}
+	if slices.Index(order, requestBody.Section) != int(requestBody.From) {
+		type response struct {
+			Sections []sectionPosition `json:"sections"`
+		}
+		handler.SendJsonResponse(
+			http.StatusPreconditionFailed,
+			response{
+				Sections: sectionPositions})
+		return
+	}
+	if requestBody.To < 0 || requestBody.To >= int64(len(order)) {
+		handler.SendBadRequest("invalid to")
+		return
+	}
+
+	// Take the section out, and put it back in at its new index
+	order = slices.Delete(order, int(requestBody.From), int(requestBody.From)+1)
+	order = slices.Insert(order, int(requestBody.To), requestBody.Section)
+
+	// Update positions (only the sections whose positions actually change are written)
+	now := handler.now().Unix()
+	for position, section := range order {
+		_, err = sqliteUpdateSectionPosition(handler, int64(position), now, section, *store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Read back the store's sections in their new order
+	sectionPositions, err = sqliteGetSectionPositionsByStore(handler, *store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64             `json:"data_version"`
+		Sections    []sectionPosition `json:"sections"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Sections:    sectionPositions})
+}
+
+// POST /api/move-section-before
+//
+// Move a section to just before another section of the same store, or to the end if "before" is null. Only the
+// sections whose positions actually change are updated.
+func handleMoveSectionBefore(handler *Handler) {
+	var requestBody struct {
+		Section int64  `json:"section"`
+		Before  *int64 `json:"before"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Get the section's store. If the section doesn't exist, 409
+	store, err := sqliteGetSectionStore(handler, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if store == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Compute the new order: take the section out, then put it back in before the target (or at the end)
+	sectionPositions, err := sqliteGetSectionPositionsByStore(handler, *store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	order := []int64{}
+	for _, sectionPosition := range sectionPositions {
+		if sectionPosition.Id != requestBody.Section {
+			order = append(order, sectionPosition.Id)
+		}
+	}
+	if requestBody.Before == nil {
+		order = append(order, requestBody.Section)
+	} else if *requestBody.Before == requestBody.Section {
+		order = nil // Moving a section before itself: nothing to do
+	} else {
+		index := slices.Index(order, *requestBody.Before)
+		if index == -1 {
+			// The target section doesn't exist, or is in a different store
+			handler.SendConflict()
+			return
+		}
+		order = slices.Insert(order, index, requestBody.Section)
+	}
+
+	// Update positions
+	now := handler.now().Unix()
+	for position, section := range order {
+		_, err = sqliteUpdateSectionPosition(handler, int64(position), now, section, *store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Read back the store's sections in their new order
+	sectionPositions, err = sqliteGetSectionPositionsByStore(handler, *store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64             `json:"data_version"`
+		Sections    []sectionPosition `json:"sections"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Sections:    sectionPositions})
+}
+
+// POST /api/rename-item
+//
+// Responds with the new name and the name it replaced, so the client can offer to undo.
+func handleRenameItem(handler *Handler) {
+	var requestBody struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxItemName)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Read the name being replaced. If the item doesn't exist, 409
+	previousName, err := sqliteGetItemName(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if previousName == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Get whether another item already has the requested name. If it does, 409. (The item itself having it is fine;
+	// renaming it to its current name just succeeds.)
+	exists, err := sqliteExistsOtherItemByName(handler, name, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if exists {
+		handler.SendConflict()
+		return
+	}
+
+	// Update this item's name to the requested name
+	_, err = sqliteUpdateItemName(handler, name, handler.now().Unix(), requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion  int64  `json:"data_version"`
+		Name         string `json:"name"`
+		PreviousName string `json:"previous_name"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:  dataVersion,
+			Name:         name,
+			PreviousName: *previousName})
+}
+
+// POST /api/rename-section
+//
+// Responds with the new name and the name it replaced, so the client can offer to undo.
+func handleRenameSection(handler *Handler) {
+	var requestBody struct {
+		Id    int64  `json:"id"`
+		Store int64  `json:"store"`
+		Name  string `json:"name"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxSectionName)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// If the section doesn't exist, 409
+	store, err := sqliteGetSectionStore(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if store == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Read the name being replaced
+	previousName, err := sqliteGetSectionName(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if previousName == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// If another section in the store already has this name (ignoring case), 409 with its id. Changing just the case
+	// of the section's own name is fine.
+	existingId, err := sqliteGetSectionIdByNameCaseInsensitive(handler, *store, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if existingId != nil && *existingId != requestBody.Id {
+		type details struct {
+			Id int64 `json:"id"`
+		}
+		handler.SendErrorWithDetails(http.StatusConflict, "name_taken", "name taken", details{Id: *existingId})
+		return
+	}
+
+	// Update this section's name to the requested name
+	result, err := sqliteUpdateSectionName(handler, name, handler.now().Unix(), requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If section doesn't exist (so no row updated), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion  int64  `json:"data_version"`
+		Name         string `json:"name"`
+		PreviousName string `json:"previous_name"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:  dataVersion,
+			Name:         name,
+			PreviousName: *previousName})
+}
+
+// POST /api/rename-store
+//
+// Responds with the new name and the name it replaced, so the client can offer to undo.
+func handleRenameStore(handler *Handler) {
+	var requestBody struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxStoreName)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Read the name being replaced. If the store doesn't exist, 409
+	previousName, err := sqliteGetStoreName(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if previousName == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Get whether another store already has the requested name. If it does, 409. (The store itself having it is fine;
+	// renaming it to its current name just succeeds.)
+	exists, err := sqliteExistsOtherStoreByName(handler, name, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if exists {
+		handler.SendConflict()
+		return
+	}
+
+	// Update this store's name to the requested name
+	_, err = sqliteUpdateStoreName(handler, name, handler.now().Unix(), requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion  int64  `json:"data_version"`
+		Name         string `json:"name"`
+		PreviousName string `json:"previous_name"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:  dataVersion,
+			Name:         name,
+			PreviousName: *previousName})
+}
+
+// POST /api/rename-unit
+//
+// Change the unit of every item with unit "from" (ignoring case) to "to", e.g. to tidy up after several spellings crept
+// in. "to" is stored in its canonical spelling if it has one (see GET /api/units). Responds with how many items it
+// changed.
+func handleRenameUnit(handler *Handler) {
+	var requestBody struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	from := trimToNil(&requestBody.From)
+	if from == nil {
+		handler.SendBadRequest("empty from")
+		return
+	}
+	to := normalizeUnit(trimToNil(&requestBody.To))
+	if to == nil {
+		handler.SendBadRequest("empty to")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update matching items' units
+	result, err := sqliteRenameUnit(handler, *from, *to, handler.now().Unix())
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	changed, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64  `json:"data_version"`
+		Unit        string `json:"unit"`
+		Changed     int64  `json:"changed"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Unit:        *to,
+			Changed:     changed})
+}
+
+// POST /api/reorder-sections
+//
+// sections must be exactly the store's section ids, in their new order. A repeated id is a 400 "duplicate_section", no
+// ids at all (for a store that has sections) is a 400 "empty_sections", and any other mismatch is a 409.
+func handleReorderSections(handler *Handler) {
+	var requestBody struct {
+		Store    int64   `json:"store"`
+		Sections []int64 `json:"sections"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Reject duplicate section ids outright. (The permutation check below would catch these too, but don't rely on it)
+	seen := map[int64]bool{}
+	for _, section := range requestBody.Sections {
+		if seen[section] {
+			handler.SendError(http.StatusBadRequest, "duplicate_section", "duplicate section")
+			return
+		}
+		seen[section] = true
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the provided section ids are a permutation of the store's sections
+	rows, err := sqliteGetSectionIdsByStore(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	var theSections []int64
+	for rows.Next() {
+		var section int64
+		err = rows.Scan(&section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		theSections = append(theSections, section)
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if len(requestBody.Sections) == 0 && len(theSections) > 0 {
+		handler.SendError(http.StatusBadRequest, "empty_sections", "empty sections")
+		return
+	}
+	if !slices.Equal(theSections, slices.Sorted(slices.Values(requestBody.Sections))) {
+		handler.SendConflict()
+		return
+	}
+
+	// Update all section positions to their position in the list
+	now := handler.now().Unix()
+	for position, section := range requestBody.Sections {
+		_, err = sqliteUpdateSectionPosition(handler, int64(position), now, section, requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Read back the store's sections in their new order, so the client can confirm its optimistic ordering
+	sectionPositions, err := sqliteGetSectionPositionsByStore(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64             `json:"data_version"`
+		Sections    []sectionPosition `json:"sections"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Sections:    sectionPositions})
+}
+
+// POST /api/reorder-store-items
+//
+// Set the walk order of the items in one section of a store (or of the store's unsectioned items, if section is null).
+// The items must be exactly the items the store sells in that section.
+func handleReorderStoreItems(handler *Handler) {
+	var requestBody struct {
+		Store   int64   `json:"store"`
+		Section *int64  `json:"section"`
+		Items   []int64 `json:"items"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Reject duplicate item ids outright
+	seen := map[int64]bool{}
+	for _, item := range requestBody.Items {
+		if seen[item] {
+			handler.SendBadRequest("duplicate item")
+			return
+		}
+		seen[item] = true
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the provided item ids are a permutation of the section's items
+	itemOrders, err := sqliteGetStoreSectionItemOrder(handler, requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	theItems := []int64{}
+	for _, itemOrder := range itemOrders {
+		theItems = append(theItems, itemOrder.Item)
+	}
+	slices.Sort(theItems)
+	if !slices.Equal(theItems, slices.Sorted(slices.Values(requestBody.Items))) {
+		handler.SendConflict()
+		return
+	}
+
+	// Update all order indexes to their position in the list
+	for orderIndex, item := range requestBody.Items {
+		_, err = sqliteUpdateItemStoreOrderIndex(handler, int64(orderIndex), item, requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Read back the section's items in their new order
+	itemOrders, err = sqliteGetStoreSectionItemOrder(handler, requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64       `json:"data_version"`
+		Items       []itemOrder `json:"items"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Items:       itemOrders})
+}
+
+// POST /api/repair-positions
+//
+// Rewrite section positions that have gaps or repeats (see GET /api/store/{id}/health) to exactly 0, 1, 2, ..., keeping
+// the sections in their current order (repeats in id order). For one store if given (404 if there's no such store),
+// else for all of them, e.g. after a bad import. Responds with each section moved, by store; stores that were already
+// fine are left out. The data version is only bumped if something moved.
+func handleRepairPositions(handler *Handler) {
+	var requestBody struct {
+		Store *int64 `json:"store"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the store exists
+	if requestBody.Store != nil {
+		store, err := sqliteGetStore(handler, *requestBody.Store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if store == nil {
+			handler.SendNotFound()
+			return
+		}
+	}
+
+	// Read sections, in order, and work out where each should be
+	rows, err := sqliteGetSectionPositionsForRepair(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer rows.Close()
+	type move struct {
+		Id   int64 `json:"id"`
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+	}
+	type storeRepair struct {
+		Store int64  `json:"store"`
+		Moves []move `json:"moves"`
+	}
+	repairs := []storeRepair{}
+	var store, position int64
+	for i := 0; rows.Next(); i++ {
+		var section sectionRow
+		err = rows.Scan(&section.Id, &section.Store, &section.Position)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if i == 0 || section.Store != store {
+			store = section.Store
+			position = 0
+		}
+		if section.Position != position {
+			if len(repairs) == 0 || repairs[len(repairs)-1].Store != store {
+				repairs = append(repairs, storeRepair{Store: store, Moves: []move{}})
+			}
+			moves := &repairs[len(repairs)-1].Moves
+			*moves = append(*moves, move{Id: section.Id, From: section.Position, To: position})
+		}
+		position++
+	}
+	err = rows.Err()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	rows.Close()
+
+	// Move them
+	now := handler.now().Unix()
+	for _, repair := range repairs {
+		for _, move := range repair.Moves {
+			_, err = sqliteUpdateSectionPosition(handler, move.To, now, move.Id, repair.Store)
+			if err != nil {
+				handler.InternalServerError(err)
+				return
+			}
+		}
+	}
+
+	// Bump data version (if anything moved)
+	var dataVersion int64
+	if len(repairs) > 0 {
+		dataVersion, err = sqliteBumpDataVersion(handler)
+	} else {
+		dataVersion, err = sqliteGetDataVersion(handler)
+	}
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64         `json:"data_version"`
+		Stores      []storeRepair `json:"stores"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Stores:      repairs})
+}
+
+// POST /api/reset
+//
+// Delete everything (items, stores, sections, trips, purchases, ...), leaving the database as if it were just created,
+// except that the data version keeps going up. For tests and demos; only exists when SHOPPING_ALLOW_RESET=1.
+func handleReset(handler *Handler) {
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Delete everything
+	err = sqliteDeleteEverything(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// No client can sync incrementally across a reset (nor needs the tombstones of everything it deleted)
+	_, err = sqliteRaiseSyncFloor(handler, dataVersion)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/restore-store
+//
+// Recreate a deleted store from the snapshot that POST /api/delete-store responded with ("store", "sections", and
+// "item_stores"). The store and its sections get new ids; item_stores rows are remapped to them. Sections keep their
+// order, and items keep their walk order. Rows for items that have since been deleted are skipped.
+//
+// The snapshot must be self-consistent (everything belongs to the store, item_stores only refer to the snapshot's
+// sections, no duplicates), else 400. If a store with the same name exists by now, 409 "name_taken" with its id.
+func handleRestoreStore(handler *Handler) {
+	var requestBody struct {
+		DataVersion int64          `json:"data_version"` // Ignored; accepted so the snapshot can be passed back whole
+		Store       storeRow       `json:"store"`
+		Sections    []sectionRow   `json:"sections"`
+		ItemStores  []itemStoreRow `json:"item_stores"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Validate the snapshot
+	name, ok := handler.ValidateName(requestBody.Store.Name, shoppingMaxStoreName)
+	if !ok {
+		return
+	}
+	if !validTaxRate(requestBody.Store.TaxRate) {
+		handler.SendBadRequest("invalid tax_rate")
+		return
+	}
+	sectionIds := map[int64]bool{}
+	sectionNames := map[string]bool{}
+	for i, section := range requestBody.Sections {
+		if section.Store != requestBody.Store.Id {
+			handler.SendBadRequest("section belongs to another store")
+			return
+		}
+		if sectionIds[section.Id] {
+			handler.SendBadRequest("duplicate section")
+			return
+		}
+		sectionIds[section.Id] = true
+		sectionName, ok := handler.ValidateName(section.Name, shoppingMaxSectionName)
+		if !ok {
+			return
+		}
+		if sectionNames[lowerAscii(sectionName)] {
+			handler.SendBadRequest("duplicate section name")
+			return
+		}
+		sectionNames[lowerAscii(sectionName)] = true
+		requestBody.Sections[i].Name = sectionName
+	}
+	itemIds := map[int64]bool{}
+	for _, itemStore := range requestBody.ItemStores {
+		if itemStore.Store != requestBody.Store.Id {
+			handler.SendBadRequest("item_store belongs to another store")
+			return
+		}
+		if itemIds[itemStore.Item] {
+			handler.SendBadRequest("duplicate item")
+			return
+		}
+		itemIds[itemStore.Item] = true
+		if itemStore.Section != nil && !sectionIds[*itemStore.Section] {
+			handler.SendBadRequest("item_store refers to unknown section")
+			return
+		}
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// If a store with this name exists by now, 409 with its id
+	existingId, err := sqliteGetStoreIdByName(handler, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if existingId != nil {
+		type details struct {
+			Id int64 `json:"id"`
+		}
+		handler.SendErrorWithDetails(http.StatusConflict, "name_taken", "name taken", details{Id: *existingId})
+		return
+	}
+
+	// Create store
+	now := handler.now().Unix()
+	storeId, err := sqliteInsertStore(handler, name, now, requestBody.Store.TaxRate, requestBody.Store.LoyaltyNote)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Create sections, in their original order (new sections go at the end), remembering their new ids
+	sections := slices.Clone(requestBody.Sections)
+	slices.SortFunc(sections, func(a, b sectionRow) int {
+		if a.Position != b.Position {
+			return cmp.Compare(a.Position, b.Position)
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+	newSectionIds := map[int64]int64{}
+	for _, section := range sections {
+		newSectionIds[section.Id], _, err = sqliteInsertSection(handler, storeId, section.Name, now, trimToNil(section.Aisle))
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Create item_stores rows, skipping items that no longer exist
+	skippedItems := []int64{}
+	for _, itemStore := range requestBody.ItemStores {
+		exists, err := sqliteExistsItemById(handler, itemStore.Item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !exists {
+			skippedItems = append(skippedItems, itemStore.Item)
+			continue
+		}
+		var section *int64
+		if itemStore.Section != nil {
+			newSectionId := newSectionIds[*itemStore.Section]
+			section = &newSectionId
+		}
+		_, err = sqliteUpsertItemStore(handler, itemStore.Item, storeId, itemStore.Sold, section)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		_, err = sqliteUpdateItemStoreOrderIndex(handler, itemStore.OrderIndex, itemStore.Item, storeId)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		_, err = sqliteUpdateItemStorePrice(handler, itemStore.PriceCents, itemStore.Item, storeId)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion  int64   `json:"data_version"`
+		Id           int64   `json:"id"`
+		SkippedItems []int64 `json:"skipped_items"`
+	}
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion:  dataVersion,
+			Id:           storeId,
+			SkippedItems: skippedItems})
+}
+
+// POST /api/save-template
+//
+// Save the items currently on the shopping list as a named template, e.g. "camping trip", to be put back on the list
+// later with /api/apply-template. The name must not belong to another template, else 409.
+func handleSaveTemplate(handler *Handler) {
+	var requestBody struct {
+		Name string `json:"name"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	name, ok := handler.ValidateName(requestBody.Name, shoppingMaxTemplateName)
+	if !ok {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the name is free
+	nameTaken, err := sqliteExistsTemplateByName(handler, name)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if nameTaken {
+		handler.SendConflict()
+		return
+	}
+
+	// Insert template, with the items on shopping list
+	id, err := sqliteInsertTemplate(handler, name, handler.now().Unix())
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	result, err := sqliteInsertTemplateItemsFromList(handler, id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	items, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Id          int64 `json:"id"`
+		Items       int64 `json:"items"`
+	}
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion: dataVersion,
+			Id:          id,
+			Items:       items})
+}
+
+// POST /api/section-items-off
+//
+// Move every item on the shopping list in a section off it, e.g. after getting everything from the dairy aisle. Each is
+// recorded as a purchase at the store. The section must belong to the store, else 409. Responds with how many items
+// were moved off the list.
+func handleSectionItemsOff(handler *Handler) {
+	var requestBody struct {
+		Store   int64 `json:"store"`
+		Section int64 `json:"section"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the section belongs to the store
+	storeSectionExists, err := sqliteExistsSectionByStoreIdSectionId(handler, requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !storeSectionExists {
+		handler.SendConflict()
+		return
+	}
+
+	// Record purchases, and move the section's items off shopping list
+	now := handler.now().Unix()
+	_, err = sqliteInsertSectionPurchases(handler, now, requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	result, err := sqliteSectionItemsOffList(handler, now, requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	removed, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Removed     int64 `json:"removed"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Removed:     removed})
+}
+
+// POST /api/section-items-on
+//
+// Put every item in a section onto the shopping list, e.g. to restock a whole spice rack. The section must belong to
+// the store, else 409. Responds with how many items weren't already on the list.
+func handleSectionItemsOn(handler *Handler) {
+	var requestBody struct {
+		Store   int64 `json:"store"`
+		Section int64 `json:"section"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the section belongs to the store
+	storeSectionExists, err := sqliteExistsSectionByStoreIdSectionId(handler, requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !storeSectionExists {
+		handler.SendConflict()
+		return
+	}
+
+	// Move the section's items on shopping list
+	result, err := sqliteSectionItemsOnList(handler, handler.now().Unix(), requestBody.Store, requestBody.Section)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	added, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Added       int64 `json:"added"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Added:       added})
+}
+
+// POST /api/set-item-have
+//
+// Mark (or unmark) an item as one we already have at home. An item that is on the shopping list but that we have isn't
+// needed; see GET /api/needed.
+func handleSetItemHave(handler *Handler) {
+	var requestBody struct {
+		Item int64 `json:"item"`
+		Have bool  `json:"have"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update item's have flag
+	result, err := sqliteUpdateItemHave(handler, requestBody.Have, handler.now().Unix(), requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If no rows affected (item doesn't exist), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/set-item-low-stock
+//
+// Flag (or unflag) an item as running low. If "add_to_list" is set while flagging, also move the item on the shopping
+// list.
+func handleSetItemLowStock(handler *Handler) {
+	var requestBody struct {
+		Item      int64 `json:"item"`
+		LowStock  bool  `json:"low_stock"`
+		AddToList bool  `json:"add_to_list"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update item's low-stock flag
+	result, err := sqliteUpdateItemLowStock(handler, requestBody.LowStock, handler.now().Unix(), requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If no rows affected (item doesn't exist), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Possibly move item on shopping list
+	if requestBody.LowStock && requestBody.AddToList {
+		_, err = sqliteItemOnList(handler, handler.now().Unix(), requestBody.Item)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/set-item-price
+//
+// Set (or, with null, clear) what an item costs at a store, in cents. The item must already be recorded at the store
+// (see POST /api/item-in-store), else 409.
+func handleSetItemPrice(handler *Handler) {
+	var requestBody struct {
+		Item       int64  `json:"item"`
+		Store      int64  `json:"store"`
+		PriceCents *int64 `json:"price_cents"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	if requestBody.PriceCents != nil && *requestBody.PriceCents < 0 {
+		handler.SendBadRequest("invalid price_cents")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update the item's price at the store
+	result, err := sqliteUpdateItemStorePrice(handler, requestBody.PriceCents, requestBody.Item, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If no rows affected (item isn't recorded at the store), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/set-item-sold
+//
+// Flip only whether an item is sold at a store, keeping its section, so that e.g. an item that is temporarily out of
+// stock there can later be re-enabled without filing it again.
+func handleSetItemSold(handler *Handler) {
+	var requestBody struct {
+		Item  int64 `json:"item"`
+		Store int64 `json:"store"`
+		Sold  bool  `json:"sold"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update the item_store row; if there isn't one, 404
+	exists, section, err := sqliteUpdateItemStoreSold(handler, requestBody.Sold, requestBody.Item, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !exists {
+		handler.SendNotFound()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type itemStore struct {
+		Item    int64  `json:"item"`
+		Store   int64  `json:"store"`
+		Sold    bool   `json:"sold"`
+		Section *int64 `json:"section"`
+	}
+	type response struct {
+		DataVersion int64     `json:"data_version"`
+		ItemStore   itemStore `json:"item_store"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			ItemStore: itemStore{
+				Item:    requestBody.Item,
+				Store:   requestBody.Store,
+				Sold:    requestBody.Sold,
+				Section: section}})
+}
+
+// POST /api/set-item-unit
+//
+// Set (or, with null or "", clear) an item's unit of measure, e.g. "lb" or "each". Common units are stored in their
+// canonical spelling (see GET /api/units).
+func handleSetItemUnit(handler *Handler) {
+	var requestBody struct {
+		Item int64   `json:"item"`
+		Unit *string `json:"unit"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update item's unit
+	unit := normalizeUnit(trimToNil(requestBody.Unit))
+	result, err := sqliteUpdateItemUnit(handler, unit, handler.now().Unix(), requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// If no rows affected (item doesn't exist), 409
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendConflict()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64   `json:"data_version"`
+		Unit        *string `json:"unit"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Unit:        unit})
+}
+
+// POST /api/set-section-aisle
+//
+// Set (or, with null or "", clear) a section's aisle label. 404 if there's no such section.
+func handleSetSectionAisle(handler *Handler) {
+	var requestBody struct {
+		Id    int64   `json:"id"`
+		Aisle *string `json:"aisle"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update the section's aisle
+	result, err := sqliteUpdateSectionAisle(handler, trimToNil(requestBody.Aisle), handler.now().Unix(), requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendNotFound()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/start-trip
+//
+// Start a shopping trip at a store. Only one trip can be in progress at a time.
+func handleStartTrip(handler *Handler) {
+	var requestBody struct {
+		Store int64 `json:"store"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the store exists, and that there isn't already a trip in progress
+	storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !storeExists {
+		handler.SendConflict()
+		return
+	}
+	activeTripId, err := sqliteGetActiveTripId(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if activeTripId != nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Create trip
+	tripId, err := sqliteInsertTrip(handler, requestBody.Store, handler.now().Unix())
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Id          int64 `json:"id"`
+	}
+	handler.SendJsonResponse(
+		http.StatusCreated,
+		response{
+			DataVersion: dataVersion,
+			Id:          tripId})
+}
+
+// POST /api/store-items-off
+//
+// Move every item on the shopping list that a store sells off it, e.g. after a shop there without a trip. Each is
+// recorded as a purchase at the store. If the store doesn't exist, 409. Responds with how many items were moved off the
+// list.
+func handleStoreItemsOff(handler *Handler) {
+	var requestBody struct {
+		Store int64 `json:"store"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the store exists
+	storeExists, err := sqliteExistsStoreById(handler, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !storeExists {
+		handler.SendConflict()
+		return
+	}
+
+	// Record purchases, and move the store's items off shopping list
+	now := handler.now().Unix()
+	_, err = sqliteInsertStorePurchases(handler, now, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	result, err := sqliteStoreItemsOffList(handler, now, requestBody.Store)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	removed, _ := result.RowsAffected()
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+		Removed     int64 `json:"removed"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Removed:     removed})
+}
+
+// POST /api/transfer-store-items
+//
+// Copy everything recorded about which items store "from" sells (and where) to store "to", e.g. when switching to a
+// similar store. Sections are mapped by name (ignoring case) to "to"'s sections; items whose section has no counterpart
+// have none. If "delete_source" is set, "from" is then deleted.
+func handleTransferStoreItems(handler *Handler) {
+	var requestBody struct {
+		From         int64 `json:"from"`
+		To           int64 `json:"to"`
+		DeleteSource bool  `json:"delete_source"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	if requestBody.From == requestBody.To {
+		handler.SendBadRequest("can't transfer a store's items to itself")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm both stores exist
+	for _, store := range []int64{requestBody.From, requestBody.To} {
+		exists, err := sqliteExistsStoreById(handler, store)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if !exists {
+			handler.SendConflict()
+			return
+		}
+	}
+
+	// Copy item_stores rows
+	sectioned, err := sqliteCountItemStoresWithMatchingSection(handler, requestBody.From, requestBody.To)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	result, err := sqliteCopyItemStoresToStore(handler, requestBody.From, requestBody.To)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	transferred, _ := result.RowsAffected()
+
+	// Possibly delete the source store. If something still references it, 409.
+	if requestBody.DeleteSource {
+		_, err = sqliteDeleteStore(handler, requestBody.From)
+		if isSqliteForeignKeyError(err) {
+			handler.SendConflictMessage("store is still referenced")
+			return
+		}
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion   int64 `json:"data_version"`
+		Transferred   int64 `json:"transferred"`
+		Sectioned     int64 `json:"sectioned"`
+		SourceDeleted bool  `json:"source_deleted"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion:   dataVersion,
+			Transferred:   transferred,
+			Sectioned:     sectioned,
+			SourceDeleted: requestBody.DeleteSource})
+}
+
+// POST /api/trip-buy-item
+//
+// Mark an item as bought on the shopping trip in progress. It stays on the shopping list until the trip ends.
+func handleTripBuyItem(handler *Handler) {
+	var requestBody struct {
+		Item int64 `json:"item"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the item exists, and that there is a trip in progress
+	itemExists, err := sqliteExistsItemById(handler, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if !itemExists {
+		handler.SendConflict()
+		return
+	}
+	tripId, err := sqliteGetActiveTripId(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if tripId == nil {
+		handler.SendConflict()
+		return
+	}
+
+	// Record item as bought on the trip
+	_, err = sqliteInsertTripItem(handler, *tripId, requestBody.Item)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// POST /api/update-section
+//
+// Update any of a section's name and aisle label in one go, for a section edit form; omitted fields are left as they
+// are, and an aisle of "" clears it. 404 if there's no such section, and 409 if it isn't in the store. As with POST
+// /api/rename-section, if another section in the store already has the name (ignoring case), 409 "name_taken" with its
+// id. Responds with the updated section.
+func handleUpdateSection(handler *Handler) {
+	var requestBody struct {
+		Id    int64   `json:"id"`
+		Store int64   `json:"store"`
+		Name  *string `json:"name"`
+		Aisle *string `json:"aisle"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	var name *string
+	if requestBody.Name != nil {
+		validName, ok := handler.ValidateName(*requestBody.Name, shoppingMaxSectionName)
+		if !ok {
+			return
+		}
+		name = &validName
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Confirm the section exists, and belongs to the store
+	section, err := sqliteGetSection(handler, requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	if section == nil {
+		handler.SendNotFound()
+		return
+	}
+	if section.Store != requestBody.Store {
+		handler.SendConflict()
+		return
+	}
+
+	// Update the name, unless another section in the store already has it (ignoring case)
+	now := handler.now().Unix()
+	if name != nil {
+		existingId, err := sqliteGetSectionIdByNameCaseInsensitive(handler, section.Store, *name)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+		if existingId != nil && *existingId != section.Id {
+			type details struct {
+				Id int64 `json:"id"`
+			}
+			handler.SendErrorWithDetails(http.StatusConflict, "name_taken", "name taken", details{Id: *existingId})
+			return
+		}
+		_, err = sqliteUpdateSectionName(handler, *name, now, section.Id)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Update the aisle
+	if requestBody.Aisle != nil {
+		_, err = sqliteUpdateSectionAisle(handler, trimToNil(requestBody.Aisle), now, section.Id)
+		if err != nil {
+			handler.InternalServerError(err)
+			return
+		}
+	}
+
+	// Read back the section
+	section, err = sqliteGetSection(handler, section.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64       `json:"data_version"`
+		Section     *sectionRow `json:"section"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion,
+			Section:     section})
+}
+
+// POST /api/update-store-meta
+//
+// Set a store's sales tax rate (between 0 and 1, else 400) and loyalty account note. Both are replaced; leave one out
+// (or null) to clear it. 404 if there's no such store.
+func handleUpdateStoreMeta(handler *Handler) {
+	var requestBody struct {
+		Id          int64    `json:"id"`
+		TaxRate     *float64 `json:"tax_rate"`
+		LoyaltyNote *string  `json:"loyalty_note"`
+	}
+
+	// Decode request body
+	if handler.DecodeJsonRequestBody(&requestBody) {
+		return
+	}
+	if !validTaxRate(requestBody.TaxRate) {
+		handler.SendBadRequest("invalid tax_rate")
+		return
+	}
+
+	// Begin transaction
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	defer handler.SqliteRollbackTransaction()
+
+	// Update the store
+	result, err := sqliteUpdateStoreMeta(
+		handler,
+		requestBody.TaxRate,
+		trimToNil(requestBody.LoyaltyNote),
+		handler.now().Unix(),
+		requestBody.Id)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		handler.SendNotFound()
+		return
+	}
+
+	// Bump data version
+	dataVersion, err := sqliteBumpDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Commit transaction
+	err = handler.SqliteCommitTransaction()
+	if err != nil {
+		handler.InternalServerError(err)
+		return
+	}
+
+	// Send response
+	type response struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	handler.SendJsonResponse(
+		http.StatusOK,
+		response{
+			DataVersion: dataVersion})
+}
+
+// Query wrappers
+
+func sqliteAutocompleteItems(handler *Handler, prefix string, limit int64) (*sql.Rows, error) {
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+	return handler.SqliteQuery_ManyRows(queryKeyAutocompleteItems, pattern, limit)
+}
+
+func sqliteBumpDataVersion(handler *Handler) (int64, error) {
+	handler.bumpedDataVersion = true
+	dataVersion, err := handler.SqliteQuery_OneRow_Int64(queryKeyBumpDataVersion)
+	if err != nil {
+		return 0, err
+	}
+	handler.newDataVersion = dataVersion
+	_, err = handler.SqliteQuery_ZeroRows(queryKeyBumpDataVersionHighWater, dataVersion)
+	return dataVersion, err
+}
+
+func sqliteClearList(handler *Handler, now int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyClearList, now)
+}
+
+// Copy a store's item_stores rows to another store, mapping each section to the target store's section with the same
+// name (ignoring case), or to null if there isn't one. Rows the target store already has are overwritten.
+func sqliteCopyItemStoresToStore(handler *Handler, from int64, to int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyCopyItemStoresToStore, from, to)
+}
+
+// How many of a store's item_stores rows have a section that another store has a section with the same name as.
+func sqliteCountItemStoresWithMatchingSection(handler *Handler, from int64, to int64) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyCountItemStoresWithMatchingSection, from, to)
+}
+
+// Delete every item, store, and trip, and (by cascading) everything that refers to them.
+func sqliteDeleteEverything(handler *Handler) error {
+	for _, key := range []queryKey{queryKeyDeleteAllItems, queryKeyDeleteAllStores, queryKeyDeleteAllTemplates, queryKeyDeleteAllTrips} {
+		_, err := handler.SqliteQuery_ZeroRows(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqliteDeleteItem(handler *Handler, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyDeleteItem, id)
+}
+
+func sqliteDeleteSection(handler *Handler, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyDeleteSection, id)
+}
+
+func sqliteDeleteStore(handler *Handler, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyDeleteStore, id)
+}
+
+func sqliteEndTrip(handler *Handler, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyEndTrip, now, id)
+}
+
+func sqliteExistsItemById(handler *Handler, id int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsItemById, id)
+}
+
+func sqliteExistsItemByName(handler *Handler, name string) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsItemByName, name)
+}
+
+// Whether an item other than the given one has the given name.
+func sqliteExistsOtherItemByName(handler *Handler, name string, id int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsOtherItemByName, name, id)
+}
+
+// Whether a store other than the given one has the given name.
+func sqliteExistsOtherStoreByName(handler *Handler, name string, id int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsOtherStoreByName, name, id)
+}
+
+func sqliteExistsSectionByStoreIdSectionId(handler *Handler, store int64, section int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsSectionByStoreIdSectionId, store, section)
+}
+
+func sqliteExistsStoreById(handler *Handler, id int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsStoreById, id)
+}
+
+type trip struct {
+	Id        int64   `json:"id"`
+	Store     *int64  `json:"store"`
+	StartedAt int64   `json:"started_at"`
+	Items     []int64 `json:"items"`
+}
+
+func sqliteExistsTemplateById(handler *Handler, id int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsTemplateById, id)
+}
+
+func sqliteExistsTemplateByName(handler *Handler, name string) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsTemplateByName, name)
+}
+
+func sqliteGetActiveTrip(handler *Handler) (*trip, error) {
+	row := handler.SqliteQuery_ZeroOrOneRows(queryKeyGetActiveTrip)
+	trip := trip{Items: []int64{}}
+	err := row.Scan(&trip.Id, &trip.Store, &trip.StartedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &trip, nil
+}
+
+func sqliteGetActiveTripId(handler *Handler) (*int64, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_Int64(queryKeyGetActiveTripId)
+}
+
+// SHA-256 of everything GET /api/items returns (except the data version and derived fields). Each table (items, stores,
+// sections, item_stores) is written as its name on a line, followed by one line per row, by primary key: a JSON array
+// of the row's fields, in the same order as in GET /api/items (with booleans as 0 or 1).
+func sqliteGetChecksum(handler *Handler) (string, error) {
+	hash := sha256.New()
+	tables := []struct {
+		name string
+		key  queryKey
+	}{
+		{"items", queryKeyGetChecksumItems},
+		{"stores", queryKeyGetChecksumStores},
+		{"sections", queryKeyGetChecksumSections},
+		{"item_stores", queryKeyGetChecksumItemStores},
+	}
+	for _, table := range tables {
+		fmt.Fprintln(hash, table.name)
+		rows, err := handler.SqliteQuery_ManyRows(table.key)
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			return "", err
+		}
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		encoder := json.NewEncoder(hash)
+		for rows.Next() {
+			err = rows.Scan(pointers...)
+			if err != nil {
+				return "", err
+			}
+			err = encoder.Encode(values)
+			if err != nil {
+				return "", err
+			}
+		}
+		err = rows.Err()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func sqliteGetDataVersion(handler *Handler) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyGetDataVersion)
+}
+
+// CreatedAt and UpdatedAt are unix times, or 0 if unknown (for an item last changed before they were recorded).
+type itemRow struct {
+	Id        int64    `json:"id"`
+	Name      string   `json:"name"`
+	OnList    bool     `json:"on_list"`
+	LowStock  bool     `json:"low_stock"`
+	Have      bool     `json:"have"`
+	Quantity  *float64 `json:"quantity"`
+	Unit      *string  `json:"unit"`
+	Note      *string  `json:"note"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+func sqliteGetDeletedItemsSince(handler *Handler, since int64) ([]int64, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetDeletedItemsSince, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// The (item, store) keys of item_stores rows deleted since the given data version.
+func sqliteGetDeletedItemStoresSince(handler *Handler, since int64) ([][2]int64, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetDeletedItemStoresSince, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := [][2]int64{}
+	for rows.Next() {
+		var item, store int64
+		err = rows.Scan(&item, &store)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, [2]int64{item, store})
+	}
+	return keys, rows.Err()
+}
+
+func sqliteGetDeletedSectionsSince(handler *Handler, since int64) ([]int64, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetDeletedSectionsSince, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func sqliteGetDeletedStoresSince(handler *Handler, since int64) ([]int64, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetDeletedStoresSince, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Items whose names collide ignoring case, ordered so that colliding items are adjacent.
+func sqliteGetDuplicateItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetDuplicateItems)
+}
+
+// Sections of a store that don't have any items in them.
+func sqliteGetEmptySectionIds(handler *Handler, storeId int64) ([]int64, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetEmptySectionIds, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func sqliteGetItem(handler *Handler, id int64) (*itemRow, error) {
+	row := handler.SqliteQuery_ZeroOrOneRows(queryKeyGetItem, id)
+	var item itemRow
+	err := row.Scan(&item.Id, &item.Name, &item.OnList, &item.LowStock, &item.Have, &item.Quantity, &item.Unit, &item.Note, &item.CreatedAt, &item.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func sqliteGetItemIdByName(handler *Handler, name string) (*int64, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_Int64(queryKeyGetItemIdByName, name)
+}
+
+func sqliteGetItemName(handler *Handler, id int64) (*string, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_String(queryKeyGetItemName, id)
+}
+
+// Every item's name, as a set.
+func sqliteGetItemNames(handler *Handler) (map[string]bool, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetItemNames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+func sqliteGetItemOnList(handler *Handler, id int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyGetItemOnList, id)
+}
+
+type itemStoreRow struct {
+	Item       int64  `json:"item"`
+	Store      int64  `json:"store"`
+	Sold       bool   `json:"sold"`
+	Section    *int64 `json:"section"`
+	OrderIndex int64  `json:"order_index"`
+	PriceCents *int64 `json:"price_cents"`
+}
+
+func scanItemStoreRows(rows *sql.Rows, err error) ([]itemStoreRow, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	itemStores := []itemStoreRow{}
+	for rows.Next() {
+		var itemStore itemStoreRow
+		err = rows.Scan(&itemStore.Item, &itemStore.Store, &itemStore.Sold, &itemStore.Section, &itemStore.OrderIndex, &itemStore.PriceCents)
+		if err != nil {
+			return nil, err
+		}
+		itemStores = append(itemStores, itemStore)
+	}
+	return itemStores, rows.Err()
+}
+
+func sqliteGetItemsChangedSince(handler *Handler, since int64) ([]itemRow, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetItemsChangedSince, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []itemRow{}
+	for rows.Next() {
+		var item itemRow
+		err = rows.Scan(&item.Id, &item.Name, &item.OnList, &item.LowStock, &item.Have, &item.Quantity, &item.Unit, &item.Note, &item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Items matching the given filters; a nil filter matches every item.
+func sqliteGetItemsFiltered(handler *Handler, hasNote *bool, store *int64, section *int64) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetItemsFiltered, hasNote, store, section)
+}
+
+func sqliteGetItemStoreCounts(handler *Handler) (map[int64]int64, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetItemStoreCounts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[int64]int64{}
+	for rows.Next() {
+		var item, count int64
+		err = rows.Scan(&item, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[item] = count
+	}
+	return counts, rows.Err()
+}
+
+func sqliteGetItemStoresByItem(handler *Handler, itemId int64) ([]itemStoreRow, error) {
+	return scanItemStoreRows(handler.SqliteQuery_ManyRows(queryKeyGetItemStoresByItem, itemId))
+}
+
+func sqliteGetItemStoresBySection(handler *Handler, sectionId int64) ([]itemStoreRow, error) {
+	return scanItemStoreRows(handler.SqliteQuery_ManyRows(queryKeyGetItemStoresBySection, sectionId))
+}
+
+func sqliteGetItemStoresByStore(handler *Handler, storeId int64) ([]itemStoreRow, error) {
+	return scanItemStoreRows(handler.SqliteQuery_ManyRows(queryKeyGetItemStoresByStore, storeId))
+}
+
+func sqliteGetLayoutSections(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetLayoutSections)
+}
+
+func sqliteGetLayoutStores(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetLayoutStores)
+}
+
+// Items on the list that the store doesn't sell.
+func sqliteGetListItemsNotSoldAtStore(handler *Handler, storeId int64) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetListItemsNotSoldAtStore, storeId)
+}
+
+func sqliteGetLowStockItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetLowStockItems)
+}
+
+// Items on the list that we don't already have.
+func sqliteGetNeededItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetNeededItems)
+}
+
+func sqliteGetOnListItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetOnListItems)
+}
+
+func sqliteGetOnListItemStores(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetOnListItemStores)
+}
+
+// Items on the list that aren't sold at any store.
+func sqliteGetOrphanListItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetOrphanListItems)
+}
+
+// CreatedAt and UpdatedAt are unix times, or 0 if unknown (for a section last changed before they were recorded).
+type sectionRow struct {
+	Id        int64   `json:"id"`
+	Store     int64   `json:"store"`
+	Position  int64   `json:"position"`
+	Name      string  `json:"name"`
+	CreatedAt int64   `json:"created_at"`
+	UpdatedAt int64   `json:"updated_at"`
+	Aisle     *string `json:"aisle"`
+}
+
+func sqliteGetRecentItems(handler *Handler, limit int64) ([]itemRow, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetRecentItems, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []itemRow{}
+	for rows.Next() {
+		var item itemRow
+		err = rows.Scan(&item.Id, &item.Name, &item.OnList, &item.LowStock, &item.Have, &item.Quantity, &item.Unit, &item.Note, &item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func sqliteGetSection(handler *Handler, id int64) (*sectionRow, error) {
+	row := handler.SqliteQuery_ZeroOrOneRows(queryKeyGetSection, id)
+	var section sectionRow
+	err := row.Scan(&section.Id, &section.Store, &section.Position, &section.Name, &section.CreatedAt, &section.UpdatedAt, &section.Aisle)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &section, nil
+}
+
+func sqliteGetSectionIdByNameCaseInsensitive(handler *Handler, storeId int64, name string) (*int64, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_Int64(queryKeyGetSectionIdByNameCaseInsensitive, storeId, name)
+}
+
+func sqliteGetSectionIdsByStore(handler *Handler, storeId int64) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetSectionIdsByStore, storeId)
+}
+
+type sectionPosition struct {
+	Id       int64 `json:"id"`
+	Position int64 `json:"position"`
+}
+
+// Items (if any) in each section with the given name, ignoring case, one row per item, or one row with a null item
+// for an empty section.
+func sqliteGetSectionItemsByNameCaseInsensitive(handler *Handler, name string) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetSectionItemsByNameCaseInsensitive, name)
+}
+
+func sqliteGetSectionName(handler *Handler, id int64) (*string, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_String(queryKeyGetSectionName, id)
+}
+
+// A store's sections, in order.
+func sqliteGetSectionPositionsByStore(handler *Handler, storeId int64) ([]sectionPosition, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetSectionPositionsByStore, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sectionPositions := []sectionPosition{}
+	for rows.Next() {
+		var sectionPosition sectionPosition
+		err = rows.Scan(&sectionPosition.Id, &sectionPosition.Position)
+		if err != nil {
+			return nil, err
+		}
+		sectionPositions = append(sectionPositions, sectionPosition)
+	}
+	return sectionPositions, rows.Err()
+}
+
+// The id, store, and position of every section (or only store's, if not nil), in the order POST /api/repair-positions
+// renumbers them: by store, then position, with ties broken by id.
+func sqliteGetSectionPositionsForRepair(handler *Handler, store *int64) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetSectionPositionsForRepair, store)
+}
+
+func sqliteGetSectionsByNameCaseInsensitive(handler *Handler, name string) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetSectionsByNameCaseInsensitive, name)
+}
+
+// A store's sections, in order.
+func sqliteGetSectionsByStore(handler *Handler, storeId int64) ([]sectionRow, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetSectionsByStore, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sections := []sectionRow{}
+	for rows.Next() {
+		var section sectionRow
+		err = rows.Scan(&section.Id, &section.Store, &section.Position, &section.Name, &section.CreatedAt, &section.UpdatedAt, &section.Aisle)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, section)
+	}
+	return sections, rows.Err()
+}
+
+func sqliteGetSectionStore(handler *Handler, id int64) (*int64, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_Int64(queryKeyGetSectionStore, id)
+}
+
+// CreatedAt and UpdatedAt are unix times, or 0 if unknown (for a store last changed before they were recorded).
+type storeRow struct {
+	Id          int64    `json:"id"`
+	Name        string   `json:"name"`
+	CreatedAt   int64    `json:"created_at"`
+	UpdatedAt   int64    `json:"updated_at"`
+	TaxRate     *float64 `json:"tax_rate"`
+	LoyaltyNote *string  `json:"loyalty_note"`
+}
+
+func sqliteGetStore(handler *Handler, id int64) (*storeRow, error) {
+	row := handler.SqliteQuery_ZeroOrOneRows(queryKeyGetStore, id)
+	var store storeRow
+	err := row.Scan(&store.Id, &store.Name, &store.CreatedAt, &store.UpdatedAt, &store.TaxRate, &store.LoyaltyNote)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+func sqliteGetStoreCompleteness(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetStoreCompleteness)
+}
+
+func sqliteGetStoreIdByName(handler *Handler, name string) (*int64, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_Int64(queryKeyGetStoreIdByName, name)
+}
+
+type itemOrder struct {
+	Item       int64 `json:"item"`
+	OrderIndex int64 `json:"order_index"`
+}
+
+func sqliteGetStoreName(handler *Handler, id int64) (*string, error) {
+	return handler.SqliteQuery_ZeroOrOneRows_String(queryKeyGetStoreName, id)
+}
+
+// The items a store sells in one of its sections (or with no section, if section is nil), in walk order. Ties are
+// broken by name.
+func sqliteGetStoreSectionItemOrder(handler *Handler, storeId int64, sectionId *int64) ([]itemOrder, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetStoreSectionItemOrder, storeId, sectionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	itemOrders := []itemOrder{}
+	for rows.Next() {
+		var itemOrder itemOrder
+		err = rows.Scan(&itemOrder.Item, &itemOrder.OrderIndex)
+		if err != nil {
+			return nil, err
+		}
+		itemOrders = append(itemOrders, itemOrder)
+	}
+	return itemOrders, rows.Err()
+}
+
+type tableCounts struct {
+	Items      int64
+	Stores     int64
+	Sections   int64
+	ItemStores int64
+}
+
+// How many items a store sells, and how many of those are in a section.
+func sqliteGetStoreSoldCounts(handler *Handler, storeId int64) (int64, int64, error) {
+	return handler.SqliteQuery_OneRow_Int64_Int64(queryKeyGetStoreSoldCounts, storeId)
+}
+
+func sqliteGetSyncFloor(handler *Handler) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyGetSyncFloor)
+}
+
+func sqliteGetTableCounts(handler *Handler) (tableCounts, error) {
+	var counts tableCounts
+	row := handler.SqliteQuery_ZeroOrOneRows(queryKeyGetTableCounts)
+	err := row.Scan(&counts.Items, &counts.Stores, &counts.Sections, &counts.ItemStores)
+	return counts, err
+}
+
+func sqliteGetTemplateItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetTemplateItems)
+}
+
+func sqliteGetTemplates(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetTemplates)
+}
+
+// The number of rows changed by INSERT, UPDATE, and DELETE statements on our connection, ever.
+func sqliteGetTotalChanges(handler *Handler) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyGetTotalChanges)
+}
+
+func sqliteGetTripItemIds(handler *Handler, tripId int64) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetTripItemIds, tripId)
+}
+
+func sqliteGetUnlinkedTemplateItemNames(handler *Handler, template int64) ([]string, error) {
+	rows, err := handler.SqliteQuery_ManyRows(queryKeyGetUnlinkedTemplateItemNames, template)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Items that aren't on the list, and have no item_stores rows at all.
+func sqliteGetUnusedItems(handler *Handler) (*sql.Rows, error) {
+	return handler.SqliteQuery_ManyRows(queryKeyGetUnusedItems)
+}
+
+func sqliteImportItem(handler *Handler, item itemRow) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyImportItem, item.Id, item.Name, item.OnList, item.LowStock, item.Have, item.Quantity, item.Unit, item.Note, item.CreatedAt, item.UpdatedAt)
+}
+
+func sqliteImportItemStore(handler *Handler, item int64, store int64, sold bool, section *int64, orderIndex int64, priceCents *int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyImportItemStore, item, store, sold, section, orderIndex, priceCents)
+}
+
+func sqliteImportSection(handler *Handler, section sectionRow) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyImportSection, section.Id, section.Store, section.Position, section.Name, section.CreatedAt, section.UpdatedAt, section.Aisle)
+}
+
+func sqliteImportStore(handler *Handler, store storeRow) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyImportStore, store.Id, store.Name, store.CreatedAt, store.UpdatedAt, store.TaxRate, store.LoyaltyNote)
+}
+
+func sqliteInsertItem(handler *Handler, name string, onList bool, now int64) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyInsertItem, name, onList, now, now)
+}
+
+// Record a purchase of an item, of the given quantity, or if that's nil, the quantity on the item. Returns nil if
+// there's no such item.
+func sqliteInsertPurchase(handler *Handler, store *int64, now int64, quantity *float64, item int64) (*int64, *float64, error) {
+	var id int64
+	err := handler.SqliteQuery_ZeroOrOneRows(queryKeyInsertPurchase, store, now, quantity, item).Scan(&id, &quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &id, quantity, nil
+}
+
+func sqliteInsertSection(handler *Handler, store int64, name string, now int64, aisle *string) (int64, int64, error) {
+	return handler.SqliteQuery_OneRow_Int64_Int64(queryKeyInsertSection, store, store, name, now, now, aisle)
+}
+
+func sqliteInsertSectionPurchases(handler *Handler, now int64, store int64, section int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyInsertSectionPurchases, now, store, section)
+}
+
+func sqliteInsertStore(handler *Handler, name string, now int64, taxRate *float64, loyaltyNote *string) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyInsertStore, name, now, now, taxRate, loyaltyNote)
+}
+
+func sqliteInsertStorePurchases(handler *Handler, now int64, store int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyInsertStorePurchases, now, store)
+}
+
+func sqliteInsertTemplate(handler *Handler, name string, now int64) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyInsertTemplate, name, now)
+}
+
+func sqliteInsertTemplateItemsFromList(handler *Handler, template int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyInsertTemplateItemsFromList, template)
+}
+
+func sqliteInsertTrip(handler *Handler, store int64, now int64) (int64, error) {
+	return handler.SqliteQuery_OneRow_Int64(queryKeyInsertTrip, store, now)
+}
+
+func sqliteInsertTripItem(handler *Handler, trip int64, item int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyInsertTripItem, trip, item)
+}
+
+func sqliteInsertTripPurchases(handler *Handler, now int64, trip int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyInsertTripPurchases, now, trip)
+}
+
+func sqliteItemOffList(handler *Handler, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyItemOffList, now, id)
+}
+
+func sqliteItemOnList(handler *Handler, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyItemOnList, now, id)
+}
+
+// Quantity is left alone if nil; unit and note are left alone unless their set flags are true (so they can be cleared).
+func sqliteItemOnListWithDetails(
+	handler *Handler,
+	id int64,
+	quantity *float64,
+	unit *string,
+	setUnit bool,
+	note *string,
+	setNote bool,
+	now int64,
+) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(
+		queryKeyItemOnListWithDetails,
+		quantity != nil,
+		quantity,
+		setUnit,
+		unit,
+		setNote,
+		note,
+		now,
+		id)
+}
+
+func sqliteItemStoreHasSection(handler *Handler, itemId int64, storeId int64) (bool, error) {
+	return handler.SqliteQuery_OneRow_Bool(queryKeyItemStoreHasSection, itemId, storeId)
+}
+
+func sqliteLinkTemplateItem(handler *Handler, item int64, template int64, name string) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyLinkTemplateItem, item, template, name)
+}
+
+func sqliteLinkTemplateItemsByName(handler *Handler, template int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyLinkTemplateItemsByName, template)
+}
+
+func sqliteMoveItemStoresToSection(handler *Handler, store int64, from int64, to int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyMoveItemStoresToSection, to, store, from)
+}
+
+// Make version the oldest data version a client can sync incrementally from (unless it already is a later one), for
+// when the rows' version stamps from before it can no longer be trusted. A trigger drops the tombstones at or below it.
+func sqliteRaiseSyncFloor(handler *Handler, version int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyRaiseSyncFloor, version)
+}
+
+// Change every item whose unit is from (ignoring case, or its canonical spelling) to have unit to instead.
+func sqliteRenameUnit(handler *Handler, from string, to string, now int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyRenameUnit, to, now, from, *normalizeUnit(&from))
+}
+
+func sqliteSectionItemsOffList(handler *Handler, now int64, store int64, section int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeySectionItemsOffList, now, store, section)
+}
+
+// Put every item in a section onto the shopping list.
+func sqliteSectionItemsOnList(handler *Handler, now int64, store int64, section int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeySectionItemsOnList, now, store, section)
+}
+
+func sqliteStoreItemsOffList(handler *Handler, now int64, store int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyStoreItemsOffList, now, store)
+}
+
+func sqliteTemplateItemsOnList(handler *Handler, now int64, template int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyTemplateItemsOnList, now, template)
+}
+
+func sqliteTripItemsOffList(handler *Handler, now int64, trip int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyTripItemsOffList, now, trip)
+}
+
+func sqliteUpdateItemHave(handler *Handler, have bool, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemHave, have, now, id)
+}
+
+func sqliteUpdateItemLowStock(handler *Handler, lowStock bool, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemLowStock, lowStock, now, id)
+}
+
+func sqliteUpdateItemName(handler *Handler, name string, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemName, name, now, id)
+}
+
+func sqliteUpdateItemQuantity(handler *Handler, quantity *float64, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemQuantity, quantity, now, id)
+}
+
+func sqliteUpdateItemStoreOrderIndex(handler *Handler, orderIndex int64, itemId int64, storeId int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemStoreOrderIndex, orderIndex, itemId, storeId)
+}
+
+func sqliteUpdateItemStorePrice(handler *Handler, priceCents *int64, itemId int64, storeId int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemStorePrice, priceCents, itemId, storeId)
+}
+
+// Returns whether the item_store row exists, and its (unchanged) section.
+func sqliteUpdateItemStoreSold(handler *Handler, sold bool, itemId int64, storeId int64) (bool, *int64, error) {
+	var section *int64
+	err := handler.SqliteQuery_ZeroOrOneRows(queryKeyUpdateItemStoreSold, sold, itemId, storeId).Scan(&section)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, section, nil
+}
+
+func sqliteUpdateItemUnit(handler *Handler, unit *string, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemUnit, unit, now, id)
+}
+
+func sqliteUpdateSectionAisle(handler *Handler, aisle *string, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateSectionAisle, aisle, now, id)
+}
+
+func sqliteUpdateSectionName(handler *Handler, name string, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateSectionName, name, now, id)
+}
+
+// Only touches the section (and its updated_at) if its position actually changes.
+func sqliteUpdateSectionPosition(handler *Handler, position int64, now int64, id int64, store int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateSectionPosition, position, now, id, store, position)
+}
+
+func sqliteUpdateStoreMeta(handler *Handler, taxRate *float64, loyaltyNote *string, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateStoreMeta, taxRate, loyaltyNote, now, id)
+}
+
+func sqliteUpdateStoreName(handler *Handler, name string, now int64, id int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpdateStoreName, name, now, id)
+}
+
+func sqliteUpsertItemStore(handler *Handler, item int64, store int64, sold bool, section *int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpsertItemStore, item, store, sold, section)
+}
+
+func sqliteUpsertItemStoreSold(handler *Handler, item int64, store int64) (sql.Result, error) {
+	return handler.SqliteQuery_ZeroRows(queryKeyUpsertItemStoreSold, item, store)
+}
+
+// Items dump cache
+//
+// The full GET /api/items response only changes when the data version is bumped, so we keep the most recently built
+// one in memory (serialized, and gzipped), keyed by the data version it was built from. A newer data version makes it
+// stale; it is replaced the next time the response is built. Responses bigger than SHOPPING_ITEMS_CACHE_MAX_BYTES are
+// not cached, and are just built from scratch every time.
+
+type itemsDumpCacheEntry struct {
+	dataVersion int64
+	json        []byte
+	gzippedJson []byte
+}
+
+var itemsDumpCache *itemsDumpCacheEntry
+var itemsDumpCacheMutex sync.Mutex
+
+func getCachedItemsDump(dataVersion int64) *itemsDumpCacheEntry {
+	itemsDumpCacheMutex.Lock()
+	defer itemsDumpCacheMutex.Unlock()
+	if itemsDumpCache != nil && itemsDumpCache.dataVersion == dataVersion {
+		return itemsDumpCache
+	}
+	return nil
+}
+
+func clearCachedItemsDump() {
+	itemsDumpCacheMutex.Lock()
+	defer itemsDumpCacheMutex.Unlock()
+	itemsDumpCache = nil
+}
+
+func putCachedItemsDump(dataVersion int64, json []byte) (*itemsDumpCacheEntry, error) {
+	var gzippedJson bytes.Buffer
+	writer := gzip.NewWriter(&gzippedJson)
+	_, err := writer.Write(json)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &itemsDumpCacheEntry{
+		dataVersion: dataVersion,
+		json:        json,
+		gzippedJson: gzippedJson.Bytes()}
+
+	if len(entry.json)+len(entry.gzippedJson) <= shoppingItemsCacheMaxBytes {
+		itemsDumpCacheMutex.Lock()
+		defer itemsDumpCacheMutex.Unlock()
+		if itemsDumpCache == nil || itemsDumpCache.dataVersion < dataVersion {
+			itemsDumpCache = entry
+		}
+	}
+
+	return entry, nil
 }
 
-// Query wrappers
+// Checksum cache
+//
+// Like the items dump cache, but for GET /api/checksum.
 
-func sqliteBumpDataVersion(handler *Handler) (int64, error) {
-	return handler.SqliteQuery_OneRow_Int64(queryKeyBumpDataVersion)
+type checksumCacheEntry struct {
+	dataVersion int64
+	checksum    string
 }
 
-func sqliteDeleteItem(handler *Handler, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyDeleteItem, id)
-}
+var checksumCache *checksumCacheEntry
+var checksumCacheMutex sync.Mutex
 
-func sqliteDeleteSection(handler *Handler, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyDeleteSection, id)
+func getCachedChecksum(dataVersion int64) (string, bool) {
+	checksumCacheMutex.Lock()
+	defer checksumCacheMutex.Unlock()
+	if checksumCache != nil && checksumCache.dataVersion == dataVersion {
+		return checksumCache.checksum, true
+	}
+	return "", false
 }
 
-func sqliteDeleteStore(handler *Handler, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyDeleteStore, id)
+func clearCachedChecksum() {
+	checksumCacheMutex.Lock()
+	defer checksumCacheMutex.Unlock()
+	checksumCache = nil
 }
 
-func sqliteExistsItemById(handler *Handler, id int64) (bool, error) {
-	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsItemById, id)
+func putCachedChecksum(dataVersion int64, checksum string) {
+	checksumCacheMutex.Lock()
+	defer checksumCacheMutex.Unlock()
+	if checksumCache == nil || checksumCache.dataVersion < dataVersion {
+		checksumCache = &checksumCacheEntry{dataVersion: dataVersion, checksum: checksum}
+	}
 }
 
-func sqliteExistsItemByName(handler *Handler, name string) (bool, error) {
-	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsItemByName, name)
+// Data version subscribers
+//
+// Each GET /api/events stream subscribes to new data versions, which are published as their transactions commit. A
+// subscriber's channel holds at most one data version; publishing replaces one that hasn't been received yet, so a
+// commit never waits on a slow client, and the client just skips ahead to the latest version.
+
+var dataVersionSubscribers = map[chan int64]struct{}{}
+var dataVersionSubscribersMutex sync.Mutex
+
+func subscribeToDataVersions() chan int64 {
+	dataVersionSubscribersMutex.Lock()
+	defer dataVersionSubscribersMutex.Unlock()
+	dataVersions := make(chan int64, 1)
+	dataVersionSubscribers[dataVersions] = struct{}{}
+	return dataVersions
 }
 
-func sqliteExistsSectionByStoreIdSectionId(handler *Handler, store int64, section int64) (bool, error) {
-	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsSectionByStoreIdSectionId, store, section)
+func unsubscribeFromDataVersions(dataVersions chan int64) {
+	dataVersionSubscribersMutex.Lock()
+	defer dataVersionSubscribersMutex.Unlock()
+	delete(dataVersionSubscribers, dataVersions)
 }
 
-func sqliteExistsStoreById(handler *Handler, id int64) (bool, error) {
-	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsStoreById, id)
+func publishDataVersion(dataVersion int64) {
+	dataVersionSubscribersMutex.Lock()
+	defer dataVersionSubscribersMutex.Unlock()
+	for dataVersions := range dataVersionSubscribers {
+		select {
+		case <-dataVersions:
+		default:
+		}
+		dataVersions <- dataVersion
+	}
 }
 
-func sqliteExistsStoreByName(handler *Handler, name string) (bool, error) {
-	return handler.SqliteQuery_OneRow_Bool(queryKeyExistsStoreByName, name)
+// A tax rate is optional, but if given, must be a fraction (0.08 for 8%).
+func validTaxRate(taxRate *float64) bool {
+	return taxRate == nil || (*taxRate >= 0 && *taxRate <= 1)
 }
 
-func sqliteGetDataVersion(handler *Handler) (int64, error) {
-	return handler.SqliteQuery_OneRow_Int64(queryKeyGetDataVersion)
+// Trim an optional string, treating an empty result as absent.
+func trimToNil(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*s)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
 }
 
-func sqliteGetSectionIdsByStore(handler *Handler, storeId int64) (*sql.Rows, error) {
-	return handler.SqliteQuery_ManyRows(queryKeyGetSectionIdsByStore, storeId)
+// Lowercase a string the way SQLite's lower() does, which only folds ASCII. Section names are unique by lower(name), so
+// checks for duplicate section names made in Go must fold this way too, else they refuse names SQLite would allow.
+func lowerAscii(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
 }
 
-func sqliteInsertItem(handler *Handler, name string, onList bool) (int64, error) {
-	return handler.SqliteQuery_OneRow_Int64(queryKeyInsertItem, name, onList)
+// Units
+//
+// Units are free text, but common ones are stored in one canonical spelling, so that e.g. "lbs" and "Pounds" are both
+// stored as "lb" and quantities in them can be compared. Units not listed here are stored as given.
+
+type unit struct {
+	Unit    string   `json:"unit"`
+	Aliases []string `json:"aliases"`
 }
 
-func sqliteInsertSection(handler *Handler, store int64, name string) (int64, int64, error) {
-	return handler.SqliteQuery_OneRow_Int64_Int64(queryKeyInsertSection, store, store, name)
+var units = []unit{
+	{"bag", []string{"bags"}},
+	{"bottle", []string{"bottles"}},
+	{"box", []string{"boxes"}},
+	{"bunch", []string{"bunches"}},
+	{"can", []string{"cans", "tin", "tins"}},
+	{"cup", []string{"cups", "c"}},
+	{"dozen", []string{"doz", "dz"}},
+	{"g", []string{"gram", "grams", "gramme", "grammes", "gr"}},
+	{"gal", []string{"gallon", "gallons"}},
+	{"jar", []string{"jars"}},
+	{"kg", []string{"kgs", "kilo", "kilos", "kilogram", "kilograms", "kilogramme", "kilogrammes"}},
+	{"l", []string{"liter", "liters", "litre", "litres", "ltr"}},
+	{"lb", []string{"lbs", "pound", "pounds"}},
+	{"loaf", []string{"loaves"}},
+	{"ml", []string{"milliliter", "milliliters", "millilitre", "millilitres"}},
+	{"oz", []string{"ounce", "ounces"}},
+	{"pack", []string{"packs", "package", "packages", "pk", "pkg"}},
+	{"pc", []string{"pcs", "piece", "pieces", "ea", "each"}},
+	{"pt", []string{"pint", "pints"}},
+	{"qt", []string{"quart", "quarts"}},
+	{"tbsp", []string{"tablespoon", "tablespoons", "tbs", "tbsps"}},
+	{"tsp", []string{"teaspoon", "teaspoons", "tsps"}},
 }
 
-func sqliteInsertStore(handler *Handler, name string) (int64, error) {
-	return handler.SqliteQuery_OneRow_Int64(queryKeyInsertStore, name)
+// Lowercased unit or alias (without any trailing ".") -> canonical unit
+var canonicalUnits = func() map[string]string {
+	canonicalUnits := map[string]string{}
+	for _, unit := range units {
+		canonicalUnits[unit.Unit] = unit.Unit
+		for _, alias := range unit.Aliases {
+			canonicalUnits[alias] = unit.Unit
+		}
+	}
+	return canonicalUnits
+}()
+
+func normalizeUnit(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	canonical, ok := canonicalUnits[strings.TrimSuffix(strings.ToLower(*s), ".")]
+	if !ok {
+		return s
+	}
+	return &canonical
 }
 
-func sqliteItemOffList(handler *Handler, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyItemOffList, id)
+// Locale-aware name ordering
+
+// A comparison of names in the order people using SHOPPING_LOCALE expect (so that e.g. "Énergie" sorts with the Es,
+// not after "Zucchini"), or nil if it isn't set. Collators can't be used concurrently, so this makes a new one each
+// time.
+func localeNameCompare() func(a, b string) int {
+	if shoppingLocale == nil {
+		return nil
+	}
+	return collate.New(*shoppingLocale).CompareString
 }
 
-func sqliteItemOnList(handler *Handler, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyItemOnList, id)
+// SQLite errors
+
+func isSqliteForeignKeyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
 }
 
-func sqliteItemStoreHasSection(handler *Handler, itemId int64, storeId int64) (bool, error) {
-	return handler.SqliteQuery_OneRow_Bool(queryKeyItemStoreHasSection, itemId, storeId)
+func isSqliteBusyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Extended result codes carry the primary code in their low byte
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
 }
 
-func sqliteUpdateItemName(handler *Handler, name string, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyUpdateItemName, name, id)
+// Execute a statement, retrying with backoff for up to timeout while the database is locked by someone else.
+func execRetryingWhileLocked(db *sql.DB, query string, timeout time.Duration) error {
+	t0 := time.Now()
+	backoff := 100 * time.Millisecond
+	for {
+		_, err := db.Exec(query)
+		if err == nil || !isSqliteBusyError(err) {
+			return err
+		}
+		waited := time.Since(t0)
+		if waited >= timeout {
+			return fmt.Errorf("database is locked by another process (waited %s): %w\n", waited.Round(time.Second), err)
+		}
+		slog.Info("database is locked, retrying", "waited", waited.Round(time.Millisecond), "timeout", timeout)
+		time.Sleep(min(backoff, timeout-waited))
+		backoff = min(backoff*2, 5*time.Second)
+	}
 }
 
-func sqliteUpdateSectionName(handler *Handler, name string, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyUpdateSectionName, name, id)
+// Scheduled backups
+
+// Back up the database into dir every interval (starting now), keeping only the newest keep backups, until ctx is done.
+func runScheduledBackups(ctx context.Context, db *sql.DB, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		path, err := backupDatabase(ctx, db, dir)
+		if err != nil {
+			slog.Error("backing up database", "error", err)
+		} else {
+			slog.Info("backed up database", "path", path)
+			err = pruneBackups(dir, keep)
+			if err != nil {
+				slog.Error("pruning old backups", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
-func sqliteUpdateSectionPosition(handler *Handler, position int64, id int64, store int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyUpdateSectionPosition, position, id, store)
+// Write a consistent snapshot of the database into dir, named by the current time so that backups sort oldest-first.
+// VACUUM INTO is safe to run while the database is in use; it writes to a temporary file first so that a backup that is
+// interrupted partway never looks like a complete one.
+func backupDatabase(ctx context.Context, db *sql.DB, dir string) (string, error) {
+	name := "shopping-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	path := filepath.Join(dir, name)
+	tmpPath := path + ".tmp"
+	_ = os.Remove(tmpPath)
+	_, err := db.ExecContext(ctx, "VACUUM INTO ?", tmpPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	return path, nil
 }
 
-func sqliteUpdateStoreName(handler *Handler, name string, id int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyUpdateStoreName, name, id)
+// Delete all but the newest keep backups in dir.
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, "shopping-") && strings.HasSuffix(name, ".db") {
+			backups = append(backups, name)
+		}
+	}
+	slices.Sort(backups)
+	for len(backups) > keep {
+		err = os.Remove(filepath.Join(dir, backups[0]))
+		if err != nil {
+			return err
+		}
+		slog.Info("deleted old backup", "name", backups[0])
+		backups = backups[1:]
+	}
+	return nil
 }
 
-func sqliteUpsertItemStore(handler *Handler, item int64, store int64, sold bool, section *int64) (sql.Result, error) {
-	return handler.SqliteQuery_ZeroRows(queryKeyUpsertItemStore, item, store, sold, section)
+// Scheduled checkpoints
+
+// Checkpoint the WAL every interval (starting after the first interval), until ctx is done, so it can't grow without
+// bound on a busy server. Checkpoints are PASSIVE, so they never wait for readers or writers; pages they can't copy yet
+// are left for the next one. There's only one connection, so each checkpoint just waits its turn for it, between
+// transactions.
+func runScheduledCheckpoints(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var busy, log, checkpointed int64
+		err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &log, &checkpointed)
+		if err != nil {
+			slog.Error("checkpointing WAL", "error", err)
+			continue
+		}
+		slog.Info("checkpointed WAL", "busy", busy, "log", log, "checkpointed", checkpointed)
+	}
 }
 
 // Crash-on-panic middleware
@@ -1535,20 +8981,54 @@ func crashOnPanicMiddleware(innerHandler http.Handler) http.Handler {
 type responseWriterThatRemembersStatus struct {
 	http.ResponseWriter // embedded; methods promoted to wrapper type
 	status              int
+	wroteHeader         bool
+	timing              *requestTiming // Only non-nil when SHOPPING_SERVER_TIMING=1
 }
 
 func (writer *responseWriterThatRemembersStatus) WriteHeader(status int) {
+	if !writer.wroteHeader && writer.timing != nil {
+		writer.Header().Set("Server-Timing", writer.timing.serverTimingHeader())
+	}
 	writer.status = status
+	writer.wroteHeader = true
 	writer.ResponseWriter.WriteHeader(status)
 }
 
+func (writer *responseWriterThatRemembersStatus) Write(bytes []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+	return writer.ResponseWriter.Write(bytes)
+}
+
+// So that http.ResponseController can reach the underlying writer (e.g. to flush GET /api/events).
+func (writer *responseWriterThatRemembersStatus) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// For GET /api/ws, which takes over the connection (and responds 101 Switching Protocols on it).
+func (writer *responseWriterThatRemembersStatus) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, readWriter, err := http.NewResponseController(writer.ResponseWriter).Hijack()
+	if err == nil {
+		writer.status = http.StatusSwitchingProtocols
+		writer.wroteHeader = true
+	}
+	return conn, readWriter, err
+}
+
 func requestLoggingMiddleware(innerHandler http.Handler) http.Handler {
 	handler := func(response http.ResponseWriter, request *http.Request) {
+		t0 := time.Now()
+		var timing *requestTiming
+		if shoppingServerTiming {
+			timing = &requestTiming{t0: t0}
+			request = request.WithContext(context.WithValue(request.Context(), requestTimingKey{}, timing))
+		}
 		response2 :=
 			&responseWriterThatRemembersStatus{
 				ResponseWriter: response,
-				status:         http.StatusOK}
-		t0 := time.Now()
+				status:         http.StatusOK,
+				timing:         timing}
 		innerHandler.ServeHTTP(response2, request)
 		t1 := time.Now()
 		slog.Info(
@@ -1561,32 +9041,261 @@ func requestLoggingMiddleware(innerHandler http.Handler) http.Handler {
 	return http.HandlerFunc(handler)
 }
 
+// Gzip middleware
+//
+// Responses are gzipped if the client accepts it, and the body is at least gzipMinBytes (smaller ones barely shrink, if
+// at all). To know that, the start of the body is held back until there's enough of it, or the handler finishes or
+// flushes. Responses that are already encoded (e.g. the cached GET /api/items response), event streams, partial
+// content, and responses without a body (304 Not Modified) are passed through as-is.
+
+const gzipMinBytes = 1024
+
+type gzipResponseWriter struct {
+	http.ResponseWriter // embedded; methods promoted to wrapper type
+	request             *http.Request
+	status              int          // 0 until the handler writes the header
+	buffer              []byte       // The start of the body, held back until we decide whether to compress
+	decided             bool         // Whether we've decided whether to compress (and sent the header)
+	gzipWriter          *gzip.Writer // Non-nil if we decided to compress
+}
+
+func gzipMiddleware(innerHandler http.Handler) http.Handler {
+	handler := func(response http.ResponseWriter, request *http.Request) {
+		response2 := &gzipResponseWriter{ResponseWriter: response, request: request}
+		innerHandler.ServeHTTP(response2, request)
+		response2.finish()
+	}
+	return http.HandlerFunc(handler)
+}
+
+// Whether this response could be gzipped, depending on its size, now that its status and headers are final.
+func (writer *gzipResponseWriter) compressible() bool {
+	header := writer.Header()
+	return writer.request.Method != http.MethodHead &&
+		writer.status >= http.StatusOK &&
+		writer.status != http.StatusNoContent &&
+		writer.status != http.StatusPartialContent &&
+		writer.status != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// Send the header, gzipped or not, followed by whatever of the body was held back.
+func (writer *gzipResponseWriter) decide(compress bool) error {
+	writer.decided = true
+	buffer := writer.buffer
+	writer.buffer = nil
+	if compress {
+		header := writer.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		writer.gzipWriter = gzip.NewWriter(writer.ResponseWriter)
+		writer.ResponseWriter.WriteHeader(writer.status)
+		_, err := writer.gzipWriter.Write(buffer)
+		return err
+	}
+	writer.ResponseWriter.WriteHeader(writer.status)
+	_, err := writer.ResponseWriter.Write(buffer)
+	return err
+}
+
+func (writer *gzipResponseWriter) WriteHeader(status int) {
+	if writer.decided {
+		writer.ResponseWriter.WriteHeader(status) // Superfluous; let the underlying writer complain
+		return
+	}
+	if writer.status != 0 {
+		return // Superfluous, and the first one hasn't been sent yet
+	}
+	if status < http.StatusOK {
+		writer.ResponseWriter.WriteHeader(status) // Informational; the real header is still to come
+		return
+	}
+	writer.status = status
+	if writer.compressible() {
+		if !slices.Contains(writer.Header().Values("Vary"), "Accept-Encoding") {
+			writer.Header().Add("Vary", "Accept-Encoding")
+		}
+		if acceptsGzip(writer.request) {
+			return
+		}
+	}
+	writer.decide(false)
+}
+
+func (writer *gzipResponseWriter) Write(bytes []byte) (int, error) {
+	if writer.status == 0 {
+		writer.WriteHeader(http.StatusOK)
+	}
+	if !writer.decided {
+		writer.buffer = append(writer.buffer, bytes...)
+		if len(writer.buffer) < gzipMinBytes {
+			return len(bytes), nil
+		}
+		return len(bytes), writer.decide(true)
+	}
+	if writer.gzipWriter != nil {
+		return writer.gzipWriter.Write(bytes)
+	}
+	return writer.ResponseWriter.Write(bytes)
+}
+
+// Send what's been written so far. Whatever was held back is too small to be worth compressing.
+func (writer *gzipResponseWriter) Flush() {
+	if writer.status == 0 {
+		writer.WriteHeader(http.StatusOK)
+	}
+	if !writer.decided {
+		writer.decide(false)
+	}
+	if writer.gzipWriter != nil {
+		writer.gzipWriter.Flush()
+	}
+	http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+// So that http.ResponseController can reach the underlying writer.
+func (writer *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// For GET /api/ws, which takes over the connection, so there's nothing to compress.
+func (writer *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(writer.ResponseWriter).Hijack()
+}
+
+// Once the handler is done: send whatever was held back (which is too small to compress), or finish the gzip stream.
+func (writer *gzipResponseWriter) finish() {
+	if writer.status == 0 {
+		return // Nothing written (or hijacked); net/http takes it from here
+	}
+	if !writer.decided {
+		writer.decide(false)
+	}
+	if writer.gzipWriter != nil {
+		writer.gzipWriter.Close()
+	}
+}
+
+// Per-request timings, which the request logging middleware reports in a Server-Timing header (so they show up in
+// browser devtools) when SHOPPING_SERVER_TIMING=1. Handlers find them in the request context. All methods are no-ops on
+// a nil *requestTiming, which is what handlers have when the header is disabled.
+
+type requestTiming struct {
+	t0      time.Time     // When the request started
+	txStart time.Time     // When the current transaction began (zero if there isn't one)
+	tx      time.Duration // Total time spent in transactions
+	query   time.Duration // Total time spent executing queries
+}
+
+type requestTimingKey struct{}
+
+func requestTimingFrom(request *http.Request) *requestTiming {
+	timing, _ := request.Context().Value(requestTimingKey{}).(*requestTiming)
+	return timing
+}
+
+func (timing *requestTiming) beginTx() {
+	if timing != nil {
+		timing.txStart = time.Now()
+	}
+}
+
+func (timing *requestTiming) endTx() {
+	if timing != nil && !timing.txStart.IsZero() {
+		timing.tx += time.Since(timing.txStart)
+		timing.txStart = time.Time{}
+	}
+}
+
+func (timing *requestTiming) recordQuery(t0 time.Time) {
+	if timing != nil {
+		timing.query += time.Since(t0)
+	}
+}
+
+func (timing *requestTiming) serverTimingHeader() string {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return fmt.Sprintf("tx;dur=%.3f, db;dur=%.3f, total;dur=%.3f", ms(timing.tx), ms(timing.query), ms(time.Since(timing.t0)))
+}
+
 // Handler abstraction
 
 type Handler struct {
 	db       *sql.DB
 	logger   *slog.Logger
+	now      func() time.Time // The clock used for created_at/updated_at timestamps
 	request  *http.Request
 	response http.ResponseWriter
-	tx       *sql.Tx // The current transaction
+	timing   *requestTiming // Only non-nil when SHOPPING_SERVER_TIMING=1
+	tx       *sql.Tx        // The current transaction
+
+	// Every transaction that changes anything must bump the data version, or clients won't notice the change. To catch
+	// handlers that forget, write transactions remember total_changes() when they begin, and on commit, if anything
+	// changed but the data version wasn't bumped, we log a warning.
+	txReadOnly          bool
+	txTotalChangesBegin int64
+	bumpedDataVersion   bool
+	newDataVersion      int64 // What the data version was bumped to, published to GET /api/events on commit
 }
 
 func NewHandler(db *sql.DB, response http.ResponseWriter, request *http.Request) *Handler {
 	return &Handler{
 		db:       db,
 		logger:   slog.Default(),
+		now:      time.Now,
 		request:  request,
 		response: response,
+		timing:   requestTimingFrom(request),
 		tx:       nil}
 }
 
 // Handler abstraction - request parsing
 
+// Trim a name, and check that it's non-empty (else 400) and at most maxLength characters (else 422, with the limit in
+// the error's details as max_length, so the client can tell the user). Each kind of thing has its own limit
+// (shoppingMaxItemName, etc). If ok is false, the error response has already been sent.
+func (handler *Handler) ValidateName(name string, maxLength int) (trimmed string, ok bool) {
+	trimmed = strings.TrimSpace(name)
+	if trimmed == "" {
+		handler.SendError(http.StatusBadRequest, "empty_name", "empty name")
+		return "", false
+	}
+	if utf8.RuneCountInString(trimmed) > maxLength {
+		type details struct {
+			MaxLength int `json:"max_length"`
+		}
+		handler.SendErrorWithDetails(
+			http.StatusUnprocessableEntity,
+			"name_too_long",
+			"name too long",
+			details{MaxLength: maxLength})
+		return "", false
+	}
+	return trimmed, true
+}
+
+// Decode the request body into v, else 400 (and return true). Fields v doesn't have are refused, rather than ignored,
+// so that a misspelled field doesn't quietly leave its value at the default.
 func (handler *Handler) DecodeJsonRequestBody(v any) bool {
-	err := json.NewDecoder(handler.request.Body).Decode(v)
+	decoder := json.NewDecoder(handler.request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
 
 	if err != nil {
-		http.Error(handler.response, err.Error(), http.StatusBadRequest)
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field, _ = strconv.Unquote(field)
+			type details struct {
+				Field string `json:"field"`
+			}
+			handler.SendErrorWithDetails(
+				http.StatusBadRequest,
+				"unknown_field",
+				fmt.Sprintf("unknown field %q", field),
+				details{Field: field})
+			return true
+		}
+		handler.SendError(http.StatusBadRequest, "invalid_json", err.Error())
 		return true
 	}
 
@@ -1595,23 +9304,155 @@ func (handler *Handler) DecodeJsonRequestBody(v any) bool {
 
 // Handler abstraction - response constructing and sending
 
+// Whether the request's Accept-Encoding allows gzip (and doesn't explicitly refuse it with q=0).
+func acceptsGzip(request *http.Request) bool {
+	return acceptsToken(request.Header.Values("Accept-Encoding"), "gzip")
+}
+
+// Whether a header like Accept or Accept-Encoding (given as its values) lists token, or the wildcard "*", without
+// refusing it with q=0. The first of them listed decides.
+func acceptsToken(values []string, token string) bool {
+	for _, header := range values {
+		for element := range strings.SplitSeq(header, ",") {
+			name, params, _ := strings.Cut(element, ";")
+			name = strings.TrimSpace(name)
+			if name != token && name != "*" {
+				continue
+			}
+			quality := 1.0
+			key, value, ok := strings.Cut(params, "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				quality, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			}
+			return quality > 0
+		}
+	}
+	return false
+}
+
+// Whether the request's Accept header asks for CSV (and doesn't explicitly refuse it with q=0).
+func acceptsCsv(request *http.Request) bool {
+	return acceptsToken(request.Header.Values("Accept"), "text/csv")
+}
+
 func (handler *Handler) SendJsonResponse(statusCode int, v any) error {
-	handler.response.Header().Set("Content-Type", "application/json")
+	handler.response.Header().Set("Content-Type", "application/json; charset=utf-8")
 	handler.response.WriteHeader(statusCode)
 	return json.NewEncoder(handler.response).Encode(v)
 }
 
+// Send already-serialized JSON, gzipped if the client accepts it.
+func (handler *Handler) SendJsonBytes(statusCode int, json []byte, gzippedJson []byte) {
+	handler.response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	handler.response.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(handler.request) {
+		handler.response.Header().Set("Content-Encoding", "gzip")
+		handler.response.WriteHeader(statusCode)
+		handler.response.Write(gzippedJson)
+	} else {
+		handler.response.WriteHeader(statusCode)
+		handler.response.Write(json)
+	}
+}
+
+// Errors are sent as {"error": {"code": "conflict", "message": "..."}}. The code is stable, for clients to act on (each
+// helper below has its own, and some handlers send more specific ones). The message is for people, and may be empty.
+func (handler *Handler) SendError(statusCode int, code string, message string) {
+	handler.SendErrorWithDetails(statusCode, code, message, nil)
+}
+
+// Like SendError, with whatever else the client needs to know about the error, under "details".
+func (handler *Handler) SendErrorWithDetails(statusCode int, code string, message string, details any) {
+	type errorBody struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Details any    `json:"details,omitempty"`
+	}
+	type response struct {
+		Error errorBody `json:"error"`
+	}
+	handler.SendJsonResponse(
+		statusCode,
+		response{
+			Error: errorBody{
+				Code:    code,
+				Message: message,
+				Details: details}})
+}
+
 func (handler *Handler) InternalServerError(err error) {
 	handler.logger.Error("Unexpected error", "error", err)
-	http.Error(handler.response, "", http.StatusInternalServerError)
+	handler.SendError(http.StatusInternalServerError, "internal_error", "")
 }
 
 func (handler *Handler) SendBadRequest(message string) {
-	http.Error(handler.response, message, http.StatusBadRequest)
+	handler.SendError(http.StatusBadRequest, "bad_request", message)
+}
+
+// 400 for a query parameter with a value we don't understand, listing the values we do.
+func (handler *Handler) SendInvalidQueryParam(name string, allowed []string) {
+	handler.SendError(
+		http.StatusBadRequest,
+		"invalid_query_param",
+		fmt.Sprintf("invalid %s (expected one of: %s)", name, strings.Join(allowed, ", ")))
+}
+
+// Read an enum-like query parameter. If it's given, it must be one of the allowed values, else 400 (and ok is false).
+// If it isn't given, the result is "".
+func (handler *Handler) EnumQueryParam(name string, allowed ...string) (value string, ok bool) {
+	value = handler.request.URL.Query().Get(name)
+	if value != "" && !slices.Contains(allowed, value) {
+		handler.SendInvalidQueryParam(name, allowed)
+		return "", false
+	}
+	return value, true
+}
+
+// Read an integer query parameter, else 400 (and ok is false). If it isn't given, the result is nil.
+func (handler *Handler) Int64QueryParam(name string) (value *int64, ok bool) {
+	v := handler.request.URL.Query().Get(name)
+	if v == "" {
+		return nil, true
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		handler.SendError(http.StatusBadRequest, "invalid_query_param", "invalid "+name)
+		return nil, false
+	}
+	return &i, true
+}
+
+// Read a boolean query parameter (anything strconv.ParseBool accepts), else 400 (and ok is false). If it isn't given,
+// the result is nil.
+func (handler *Handler) BoolQueryParam(name string) (value *bool, ok bool) {
+	v := handler.request.URL.Query().Get(name)
+	if v == "" {
+		return nil, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		handler.SendInvalidQueryParam(name, []string{"true", "false"})
+		return nil, false
+	}
+	return &b, true
 }
 
 func (handler *Handler) SendConflict() {
-	http.Error(handler.response, "", http.StatusConflict)
+	handler.SendError(http.StatusConflict, "conflict", "")
+}
+
+func (handler *Handler) SendConflictMessage(message string) {
+	handler.SendError(http.StatusConflict, "conflict", message)
+}
+
+func (handler *Handler) SendNotFound() {
+	handler.SendError(http.StatusNotFound, "not_found", "")
+}
+
+// 410 for an incremental read from a point the server no longer knows the changes since; the client should read
+// everything again.
+func (handler *Handler) SendResyncRequired(message string) {
+	handler.SendError(http.StatusGone, "resync_required", message)
 }
 
 func (handler *Handler) SendOk() {
@@ -1621,30 +9462,122 @@ func (handler *Handler) SendOk() {
 // Handler abstraction - database helpers
 
 func (handler *Handler) SqliteBeginTransaction() error {
-	tx, err := handler.db.BeginTx(handler.request.Context(), nil)
+	return handler.SqliteBeginTransactionWithOptions(nil)
+}
+
+// Begin a transaction that only reads. This is a deferred transaction (plain BEGIN), which in WAL mode sees a
+// consistent snapshot of the database from its first read until it ends, even if other connections commit writes in
+// the meantime. (Today we only have one connection, so that can't happen, but read handlers shouldn't depend on it.)
+// Read endpoints do all their reading in one of these, so everything they return is as of the data version they return.
+func (handler *Handler) SqliteBeginReadTransaction() error {
+	return handler.SqliteBeginTransactionWithOptions(&sql.TxOptions{ReadOnly: true})
+}
+
+func (handler *Handler) SqliteBeginTransactionWithOptions(options *sql.TxOptions) error {
+	tx, err := handler.db.BeginTx(handler.request.Context(), options)
 	if err != nil {
 		return err
 	}
 	handler.tx = tx
+	handler.timing.beginTx()
+	handler.txReadOnly = options != nil && options.ReadOnly
+	handler.bumpedDataVersion = false
+	if !handler.txReadOnly {
+		handler.txTotalChangesBegin, err = sqliteGetTotalChanges(handler)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (handler *Handler) SqliteCommitTransaction() error {
-	return handler.tx.Commit()
+	defer handler.timing.endTx()
+	if !handler.txReadOnly && !handler.bumpedDataVersion {
+		totalChanges, err := sqliteGetTotalChanges(handler)
+		if err != nil {
+			return err
+		}
+		if totalChanges != handler.txTotalChangesBegin {
+			handler.logger.Warn(
+				"transaction changed data without bumping the data version",
+				"method", handler.request.Method,
+				"path", handler.request.URL.Path,
+				"changes", totalChanges-handler.txTotalChangesBegin)
+		}
+	}
+	err := handler.tx.Commit()
+	if err == nil && handler.bumpedDataVersion {
+		publishDataVersion(handler.newDataVersion)
+	}
+	return err
+}
+
+// For read endpoints, whose responses only change when the data version does: read the data version (in the current
+// transaction), and if the client's If-None-Match says it already has that version, respond 304 Not Modified. If done,
+// a response has been sent (the 304, or a 500).
+func (handler *Handler) SqliteGetDataVersionOrNotModified() (dataVersion int64, done bool) {
+	dataVersion, err := sqliteGetDataVersion(handler)
+	if err != nil {
+		handler.InternalServerError(err)
+		return 0, true
+	}
+	if handler.request.Header.Get("If-None-Match") == fmt.Sprintf(`"%d"`, dataVersion) {
+		handler.response.WriteHeader(http.StatusNotModified)
+		return dataVersion, true
+	}
+	return dataVersion, false
 }
 
 func (handler *Handler) SqliteRollbackTransaction() error {
+	defer handler.timing.endTx()
 	return handler.tx.Rollback()
 }
 
+func (handler *Handler) recordQueryTiming(key queryKey, t0 time.Time) {
+	recordQueryStat(key, t0)
+	handler.timing.recordQuery(t0)
+}
+
+// The prepared statement for a query, bound to the current transaction. A key with no entry in the queries map would
+// otherwise be a nil statement, which panics deep inside database/sql, so make it an ordinary error instead.
+func (handler *Handler) sqliteStmt(key queryKey) (*sql.Stmt, error) {
+	stmt := preparedQueries[key]
+	if stmt == nil {
+		return nil, fmt.Errorf("query not prepared: %s", key)
+	}
+	return handler.tx.StmtContext(handler.request.Context(), stmt), nil
+}
+
+// What SqliteQuery_ZeroOrOneRows returns: a *sql.Row, or an errRow if the query couldn't even be run.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+type errRow struct {
+	err error
+}
+
+func (row errRow) Scan(dest ...any) error {
+	return row.err
+}
+
 func (handler *Handler) SqliteQuery_ZeroRows(key queryKey, args ...any) (sql.Result, error) {
-	ctx := handler.request.Context()
-	return handler.tx.StmtContext(ctx, preparedQueries[key]).ExecContext(ctx, args...)
+	defer handler.recordQueryTiming(key, time.Now())
+	stmt, err := handler.sqliteStmt(key)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(handler.request.Context(), args...)
 }
 
-func (handler *Handler) SqliteQuery_ZeroOrOneRows(key queryKey, args ...any) *sql.Row {
-	ctx := handler.request.Context()
-	return handler.tx.StmtContext(ctx, preparedQueries[key]).QueryRowContext(ctx, args...)
+func (handler *Handler) SqliteQuery_ZeroOrOneRows(key queryKey, args ...any) rowScanner {
+	defer handler.recordQueryTiming(key, time.Now())
+	stmt, err := handler.sqliteStmt(key)
+	if err != nil {
+		return errRow{err}
+	}
+	return stmt.QueryRowContext(handler.request.Context(), args...)
 }
 
 func (handler *Handler) SqliteQuery_ZeroOrOneRows_Int64(key queryKey, args ...any) (*int64, error) {
@@ -1696,6 +9629,10 @@ func (handler *Handler) SqliteQuery_OneRow_Int64_Int64(key queryKey, args ...any
 }
 
 func (handler *Handler) SqliteQuery_ManyRows(key queryKey, args ...any) (*sql.Rows, error) {
-	ctx := handler.request.Context()
-	return handler.tx.StmtContext(ctx, preparedQueries[key]).QueryContext(ctx, args...)
+	defer handler.recordQueryTiming(key, time.Now())
+	stmt, err := handler.sqliteStmt(key)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(handler.request.Context(), args...)
 }
@@ -0,0 +1,1195 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// A fresh database in a temporary directory, behind the same routes main_serve serves.
+type testServer struct {
+	t   testing.TB
+	db  *sql.DB
+	mux *http.ServeMux
+}
+
+func newTestServer(t testing.TB) *testServer {
+	t.Helper()
+	db, err := openDatabase(filepath.Join(t.TempDir(), "shopping.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { closeDatabase(db) })
+
+	// The caches are keyed by data version, which starts over with every database.
+	clearCachedItemsDump()
+	clearCachedChecksum()
+
+	return &testServer{t: t, db: db, mux: newServeMux(db)}
+}
+
+func (server *testServer) do(request *http.Request) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	server.mux.ServeHTTP(response, request)
+	return response
+}
+
+func (server *testServer) get(path string) *httptest.ResponseRecorder {
+	return server.do(httptest.NewRequest(http.MethodGet, path, nil))
+}
+
+func (server *testServer) post(path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	return server.do(request)
+}
+
+// POST, and insist on the given status, decoding the response body into v (if not nil).
+func (server *testServer) mustPost(path string, body string, status int, v any) {
+	server.t.Helper()
+	response := server.post(path, body)
+	expectStatus(server.t, response, status)
+	if v != nil {
+		decodeResponse(server.t, response, v)
+	}
+}
+
+// Create a store with the given name, and return its id.
+func (server *testServer) createStore(name string) int64 {
+	server.t.Helper()
+	var body struct {
+		Id int64 `json:"id"`
+	}
+	server.mustPost("/api/create-store", `{"name":`+jsonString(name)+`}`, http.StatusCreated, &body)
+	return body.Id
+}
+
+// Create an item with the given name, and return its id.
+func (server *testServer) createItem(name string) int64 {
+	server.t.Helper()
+	var body struct {
+		Id int64 `json:"id"`
+	}
+	server.mustPost("/api/create-item", `{"name":`+jsonString(name)+`}`, http.StatusCreated, &body)
+	return body.Id
+}
+
+// Create a section in the given store, and return its id.
+func (server *testServer) createSection(store int64, name string) int64 {
+	server.t.Helper()
+	var body struct {
+		Id int64 `json:"id"`
+	}
+	server.mustPost(
+		"/api/create-section",
+		fmt.Sprintf(`{"store":%d,"name":%s}`, store, jsonString(name)),
+		http.StatusCreated,
+		&body)
+	return body.Id
+}
+
+func jsonString(s string) string {
+	bytes, _ := json.Marshal(s)
+	return string(bytes)
+}
+
+func expectStatus(t testing.TB, response *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if response.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", response.Code, status, response.Body)
+	}
+}
+
+func decodeResponse(t testing.TB, response *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	err := json.Unmarshal(response.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding response %s: %v", response.Body, err)
+	}
+}
+
+// Insist on an error response with the given status and code.
+func expectError(t testing.TB, response *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	expectStatus(t, response, status)
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	decodeResponse(t, response, &body)
+	if body.Error.Code != code {
+		t.Fatalf("error code = %q, want %q; body: %s", body.Error.Code, code, response.Body)
+	}
+}
+
+func TestDeleteStoreMissing(t *testing.T) {
+	server := newTestServer(t)
+	expectError(t, server.post("/api/delete-store", `{"id":12345}`), http.StatusNotFound, "not_found")
+}
+
+func TestDeleteStoreStillReferenced(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+
+	// Every real reference to a store cascades, so make one that doesn't.
+	_, err := server.db.Exec("CREATE TABLE store_refs (store INTEGER NOT NULL REFERENCES stores (id))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.db.Exec("INSERT INTO store_refs (store) VALUES (?)", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, server.post("/api/delete-store", fmt.Sprintf(`{"id":%d}`, store)), http.StatusConflict, "conflict")
+
+	// And the store is still there.
+	var stores struct {
+		Stores []struct {
+			Id int64 `json:"id"`
+		} `json:"stores"`
+	}
+	response := server.get("/api/stores")
+	expectStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &stores)
+	if len(stores.Stores) != 1 || stores.Stores[0].Id != store {
+		t.Fatalf("stores = %+v, want just %d", stores.Stores, store)
+	}
+}
+
+func TestImportTextQuantityAndUnit(t *testing.T) {
+	server := newTestServer(t)
+	response := server.post("/api/import-text", "milk, 2 l\nsalt, sea\neggs,12\nbread\n")
+	expectStatus(t, response, http.StatusOK)
+
+	var items struct {
+		Items []struct {
+			Name     string   `json:"name"`
+			Quantity *float64 `json:"quantity"`
+			Unit     *string  `json:"unit"`
+		} `json:"items"`
+	}
+	response = server.get("/api/items")
+	expectStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &items)
+	got := map[string]string{}
+	for _, item := range items.Items {
+		description := "-"
+		if item.Quantity != nil {
+			description = strconv.FormatFloat(*item.Quantity, 'f', -1, 64)
+		}
+		if item.Unit != nil {
+			description += " " + *item.Unit
+		}
+		got[item.Name] = description
+	}
+	want := map[string]string{"milk": "2 l", "salt, sea": "-", "eggs": "12", "bread": "-"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("items = %v, want %v", got, want)
+	}
+}
+
+func TestImportTextInvalidQuantity(t *testing.T) {
+	server := newTestServer(t)
+	expectError(t, server.post("/api/import-text", "bread\nmilk, 0 l\n"), http.StatusBadRequest, "bad_request")
+	expectError(t, server.post("/api/import-text", "milk, 1.2.3\n"), http.StatusBadRequest, "bad_request")
+}
+
+func TestReorderSections(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+	a := server.createSection(store, "Produce")
+	b := server.createSection(store, "Dairy")
+	reorder := func(sections string) *httptest.ResponseRecorder {
+		return server.post("/api/reorder-sections", fmt.Sprintf(`{"store":%d,"sections":%s}`, store, sections))
+	}
+
+	expectError(t, reorder(fmt.Sprintf("[%d,%d]", a, a)), http.StatusBadRequest, "duplicate_section")
+	expectError(t, reorder(fmt.Sprintf("[%d,%d,%d]", a, b, a)), http.StatusBadRequest, "duplicate_section")
+	expectError(t, reorder(fmt.Sprintf("[%d]", a)), http.StatusConflict, "conflict")
+	expectError(t, reorder(fmt.Sprintf("[%d,%d,%d]", a, b, b+100)), http.StatusConflict, "conflict")
+	expectError(t, reorder("[]"), http.StatusBadRequest, "empty_sections")
+
+	response := reorder(fmt.Sprintf("[%d,%d]", b, a))
+	expectStatus(t, response, http.StatusOK)
+	var body struct {
+		Sections []sectionPosition `json:"sections"`
+	}
+	decodeResponse(t, response, &body)
+	want := []sectionPosition{{Id: b, Position: 0}, {Id: a, Position: 1}}
+	if !slices.Equal(body.Sections, want) {
+		t.Fatalf("sections = %v, want %v", body.Sections, want)
+	}
+}
+
+func TestCheckNameMatchesCreate(t *testing.T) {
+	server := newTestServer(t)
+	milk := server.createItem("Milk")
+	aldi := server.createStore("Aldi")
+
+	type checkName struct {
+		Available  bool   `json:"available"`
+		ExistingId *int64 `json:"existing_id"`
+	}
+	check := func(kind string, name string) checkName {
+		t.Helper()
+		response := server.get("/api/check-name?type=" + kind + "&name=" + url.QueryEscape(name))
+		expectStatus(t, response, http.StatusOK)
+		var body checkName
+		decodeResponse(t, response, &body)
+		return body
+	}
+
+	if got := check("item", " Milk "); got.Available || got.ExistingId == nil || *got.ExistingId != milk {
+		t.Fatalf("check-name Milk = %+v, want taken by %d", got, milk)
+	}
+	if got := check("store", "Aldi"); got.Available || got.ExistingId == nil || *got.ExistingId != aldi {
+		t.Fatalf("check-name Aldi = %+v, want taken by %d", got, aldi)
+	}
+
+	// Names that differ only by case are available, and creating them does succeed.
+	if got := check("item", "MILK"); !got.Available {
+		t.Fatalf("check-name MILK = %+v, want available", got)
+	}
+	server.createItem("MILK")
+	if got := check("store", "ALDI"); !got.Available {
+		t.Fatalf("check-name ALDI = %+v, want available", got)
+	}
+	server.createStore("ALDI")
+
+	// Invalid names get the errors create would give.
+	expectError(t, server.get("/api/check-name?type=item&name=+"), http.StatusBadRequest, "empty_name")
+	longName := strings.Repeat("x", shoppingMaxItemName+1)
+	expectError(t, server.get("/api/check-name?type=item&name="+longName), http.StatusUnprocessableEntity, "name_too_long")
+	expectError(t, server.post("/api/create-item", `{"name":"`+longName+`"}`), http.StatusUnprocessableEntity, "name_too_long")
+}
+
+func TestReorderStoreItemsOnlySoldItems(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+	section := server.createSection(store, "Dairy")
+	milk := server.createItem("Milk")
+	cheese := server.createItem("Cheese")
+	for _, item := range []int64{milk, cheese} {
+		server.mustPost(
+			"/api/item-in-store",
+			fmt.Sprintf(`{"item":%d,"store":%d,"section":%d}`, item, store, section),
+			http.StatusOK,
+			nil)
+	}
+	server.mustPost("/api/set-item-sold", fmt.Sprintf(`{"item":%d,"store":%d,"sold":false}`, cheese, store), http.StatusOK, nil)
+
+	reorder := func(items string) *httptest.ResponseRecorder {
+		return server.post(
+			"/api/reorder-store-items",
+			fmt.Sprintf(`{"store":%d,"section":%d,"items":%s}`, store, section, items))
+	}
+	expectError(t, reorder(fmt.Sprintf("[%d,%d]", cheese, milk)), http.StatusConflict, "conflict")
+	response := reorder(fmt.Sprintf("[%d]", milk))
+	expectStatus(t, response, http.StatusOK)
+	var body struct {
+		Items []itemOrder `json:"items"`
+	}
+	decodeResponse(t, response, &body)
+	if want := []itemOrder{{Item: milk, OrderIndex: 0}}; !slices.Equal(body.Items, want) {
+		t.Fatalf("items = %v, want %v", body.Items, want)
+	}
+}
+
+// Create a database at path, with migrations up to and including version applied, for testing later migrations against
+// data they'll find in the wild.
+func createDatabaseAtVersion(t testing.TB, path string, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n <= version; n++ {
+		bytes, err := migrationFS.ReadFile(fmt.Sprintf("migrations/%04d.sql", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(bytes))
+		if err != nil {
+			t.Fatalf("migration %04d: %v", n, err)
+		}
+	}
+	_, err = db.Exec("UPDATE schema_version SET version = ?", version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrationRenamesDuplicateSectionNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	db := createDatabaseAtVersion(t, path, 14)
+	_, err := db.Exec(`
+		INSERT INTO stores (id, name) VALUES (1, 'Aldi'), (2, 'Lidl');
+		INSERT INTO sections (id, store, position, name) VALUES
+			(1, 1, 0, 'Dairy'), (2, 1, 1, 'DAIRY'), (3, 1, 2, 'dairy (2)'), (4, 1, 3, 'dairy'), (5, 2, 0, 'Dairy');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer closeDatabase(db)
+	rows, err := db.Query("SELECT name FROM sections ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	want := []string{"Dairy", "DAIRY (3)", "dairy (2)", "dairy (4)", "Dairy"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("section names = %q, want %q", names, want)
+	}
+}
+
+// The current data version, as GET /api/sync-status reports it.
+func (server *testServer) dataVersion() int64 {
+	server.t.Helper()
+	response := server.get("/api/sync-status")
+	expectStatus(server.t, response, http.StatusOK)
+	var body struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	decodeResponse(server.t, response, &body)
+	return body.DataVersion
+}
+
+func TestStartWithEmptyDataVersionTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	db, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	server := &testServer{t: t, db: db, mux: newServeMux(db)}
+	server.createItem("Milk")
+	before := server.dataVersion()
+	_, err = db.Exec("DELETE FROM data_version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeDatabase(db)
+
+	db, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("reopening database: %v", err)
+	}
+	defer closeDatabase(db)
+	server = &testServer{t: t, db: db, mux: newServeMux(db)}
+
+	// The row is back, and (thanks to the high-water mark) the version didn't go backwards.
+	after := server.dataVersion()
+	if after <= before {
+		t.Fatalf("data version after restart = %d, want more than %d", after, before)
+	}
+	server.createItem("Eggs")
+	if got := server.dataVersion(); got != after+1 {
+		t.Fatalf("data version after a change = %d, want %d", got, after+1)
+	}
+}
+
+func TestFastForwardRaisesSyncFloor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	db, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	server := &testServer{t: t, db: db, mux: newServeMux(db)}
+	server.mustPost("/api/delete-item", fmt.Sprintf(`{"id":%d}`, server.createItem("Milk")), http.StatusOK, nil)
+	since := server.dataVersion()
+
+	// As if an older copy of the database were put back.
+	_, err = db.Exec("UPDATE data_version SET version = 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeDatabase(db)
+
+	db, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("reopening database: %v", err)
+	}
+	defer closeDatabase(db)
+	server = &testServer{t: t, db: db, mux: newServeMux(db)}
+
+	// The old version can't be synced from any more, and the tombstones from before the floor are gone.
+	expectError(t, server.get(fmt.Sprintf("/api/items?since=%d", since)), http.StatusGone, "resync_required")
+	var tombstones int
+	err = db.QueryRow("SELECT COUNT(*) FROM deleted_items").Scan(&tombstones)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstones != 0 {
+		t.Fatalf("%d tombstones left below the sync floor, want 0", tombstones)
+	}
+
+	// But the new version can, tombstones included.
+	since = server.dataVersion()
+	eggs := server.createItem("Eggs")
+	server.mustPost("/api/delete-item", fmt.Sprintf(`{"id":%d}`, eggs), http.StatusOK, nil)
+	response := server.get(fmt.Sprintf("/api/items?since=%d", since))
+	expectStatus(t, response, http.StatusOK)
+	var body struct {
+		DeletedItems []int64 `json:"deleted_items"`
+	}
+	decodeResponse(t, response, &body)
+	if !slices.Equal(body.DeletedItems, []int64{eggs}) {
+		t.Fatalf("deleted_items = %v, want [%d]", body.DeletedItems, eggs)
+	}
+}
+
+func TestResetKeepsDataVersionGoingUp(t *testing.T) {
+	shoppingAllowReset = true
+	t.Cleanup(func() { shoppingAllowReset = false })
+	server := newTestServer(t)
+	server.createItem("Milk")
+	server.mustPost("/api/delete-item", fmt.Sprintf(`{"id":%d}`, server.createItem("Eggs")), http.StatusOK, nil)
+	before := server.dataVersion()
+
+	var body struct {
+		DataVersion int64 `json:"data_version"`
+	}
+	server.mustPost("/api/reset", `{}`, http.StatusOK, &body)
+	if body.DataVersion != before+1 {
+		t.Fatalf("data version after reset = %d, want %d", body.DataVersion, before+1)
+	}
+
+	// A client that synced before the reset must start over, and nothing needs the tombstones any more.
+	expectError(t, server.get(fmt.Sprintf("/api/items?since=%d", before)), http.StatusGone, "resync_required")
+	var tombstones int
+	err := server.db.QueryRow(
+		"SELECT (SELECT COUNT(*) FROM deleted_items) + (SELECT COUNT(*) FROM deleted_stores) + " +
+			"(SELECT COUNT(*) FROM deleted_sections) + (SELECT COUNT(*) FROM deleted_item_stores)",
+	).Scan(&tombstones)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstones != 0 {
+		t.Fatalf("%d tombstones left after reset, want 0", tombstones)
+	}
+
+	// Its old ETag is never current again.
+	for range before + 1 {
+		server.createItem(fmt.Sprintf("Item %d", server.dataVersion()))
+	}
+	request := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	request.Header.Set("If-None-Match", fmt.Sprintf(`"%d"`, before))
+	expectStatus(t, server.do(request), http.StatusOK)
+}
+
+func TestCommitWarnsWhenDataVersionNotBumped(t *testing.T) {
+	server := newTestServer(t)
+
+	// A mutation, as a handler would make it, returning what was logged.
+	mutate := func(name string, bump bool) string {
+		t.Helper()
+		var log bytes.Buffer
+		handler := NewHandler(server.db, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/test", nil))
+		handler.logger = slog.New(slog.NewTextHandler(&log, nil))
+		err := handler.SqliteBeginTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.SqliteRollbackTransaction()
+		_, err = sqliteInsertItem(handler, name, false, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bump {
+			_, err = sqliteBumpDataVersion(handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = handler.SqliteCommitTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return log.String()
+	}
+
+	if log := mutate("Milk", false); !strings.Contains(log, "without bumping the data version") {
+		t.Fatalf("forgetting to bump logged %q, want a warning", log)
+	}
+	if log := mutate("Eggs", true); log != "" {
+		t.Fatalf("bumping logged %q, want nothing", log)
+	}
+}
+
+func TestTransferStoreItemsUnmappedSectionBecomesNull(t *testing.T) {
+	server := newTestServer(t)
+	from := server.createStore("Aldi")
+	to := server.createStore("Lidl")
+	fromDairy := server.createSection(from, "Dairy")
+	fromBakery := server.createSection(from, "Bakery")
+	toDairy := server.createSection(to, "dairy")
+	toProduce := server.createSection(to, "Produce")
+	milk := server.createItem("Milk")
+	bread := server.createItem("Bread")
+	inStore := func(item int64, store int64, section int64) {
+		t.Helper()
+		server.mustPost(
+			"/api/item-in-store",
+			fmt.Sprintf(`{"item":%d,"store":%d,"section":%d}`, item, store, section),
+			http.StatusOK,
+			nil)
+	}
+	inStore(milk, from, fromDairy)
+	inStore(bread, from, fromBakery)
+	inStore(bread, to, toProduce)
+
+	server.mustPost("/api/transfer-store-items", fmt.Sprintf(`{"from":%d,"to":%d}`, from, to), http.StatusOK, nil)
+
+	sectionAt := func(item int64, store int64) *int64 {
+		t.Helper()
+		response := server.get(fmt.Sprintf("/api/item/%d", item))
+		expectStatus(t, response, http.StatusOK)
+		var body struct {
+			ItemStores []itemStoreRow `json:"item_stores"`
+		}
+		decodeResponse(t, response, &body)
+		for _, itemStore := range body.ItemStores {
+			if itemStore.Store == store {
+				return itemStore.Section
+			}
+		}
+		t.Fatalf("item %d has no row for store %d", item, store)
+		return nil
+	}
+	if section := sectionAt(milk, to); section == nil || *section != toDairy {
+		t.Fatalf("milk's section at %d = %v, want %d", to, section, toDairy)
+	}
+	if section := sectionAt(bread, to); section != nil {
+		t.Fatalf("bread's section at %d = %d, want null", to, *section)
+	}
+}
+
+func TestRestoreStoreWithNonAsciiCaseSections(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+
+	// SQLite's lower() only folds ASCII, so these are different section names.
+	server.createSection(store, "Énergie")
+	server.createSection(store, "énergie")
+
+	response := server.post("/api/delete-store", fmt.Sprintf(`{"id":%d}`, store))
+	expectStatus(t, response, http.StatusOK)
+	server.mustPost("/api/restore-store", response.Body.String(), http.StatusCreated, nil)
+}
+
+func TestMigratedStoresAndSectionsHaveUnknownTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	db := createDatabaseAtVersion(t, path, 9)
+	_, err := db.Exec(
+		"INSERT INTO stores (id, name) VALUES (1, 'Aldi'); " +
+			"INSERT INTO sections (store, position, name) VALUES (1, 0, 'Dairy')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer closeDatabase(db)
+	clearCachedItemsDump()
+	server := &testServer{t: t, db: db, mux: newServeMux(db)}
+
+	response := server.get("/api/items")
+	expectStatus(t, response, http.StatusOK)
+	var all struct {
+		Stores   []storeRow   `json:"stores"`
+		Sections []sectionRow `json:"sections"`
+	}
+	decodeResponse(t, response, &all)
+	if len(all.Stores) != 1 || len(all.Sections) != 1 {
+		t.Fatalf("stores = %+v, sections = %+v, want one of each", all.Stores, all.Sections)
+	}
+	if store := all.Stores[0]; store.CreatedAt != 0 || store.UpdatedAt != 0 {
+		t.Fatalf("store has timestamps %d/%d, want 0/0", store.CreatedAt, store.UpdatedAt)
+	}
+	if section := all.Sections[0]; section.CreatedAt != 0 || section.UpdatedAt != 0 {
+		t.Fatalf("section has timestamps %d/%d, want 0/0", section.CreatedAt, section.UpdatedAt)
+	}
+}
+
+func TestMigratedItemsHaveUnknownTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	db := createDatabaseAtVersion(t, path, 16)
+	_, err := db.Exec("INSERT INTO items (name, on_list) VALUES ('Milk', 0), ('Eggs', 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer closeDatabase(db)
+	clearCachedItemsDump()
+	server := &testServer{t: t, db: db, mux: newServeMux(db)}
+
+	type items struct {
+		Items []itemRow `json:"items"`
+	}
+	var all items
+	response := server.get("/api/items")
+	expectStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &all)
+	for _, item := range all.Items {
+		if item.CreatedAt != 0 || item.UpdatedAt != 0 {
+			t.Fatalf("item %q has timestamps %d/%d, want 0/0", item.Name, item.CreatedAt, item.UpdatedAt)
+		}
+	}
+
+	// So they don't look recently changed.
+	var recent items
+	response = server.get("/api/items/recent")
+	expectStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &recent)
+	if len(recent.Items) != 0 {
+		t.Fatalf("recent items = %+v, want none", recent.Items)
+	}
+	var changed items
+	response = server.get(fmt.Sprintf("/api/items/changed-since?t=%d", time.Now().Unix()-60))
+	expectStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &changed)
+	if len(changed.Items) != 0 {
+		t.Fatalf("changed items = %+v, want none", changed.Items)
+	}
+}
+
+func TestGetDuplicatesGroupsLikeSqlite(t *testing.T) {
+	server := newTestServer(t)
+
+	// SQLite's lower() only folds ASCII, so names starting with the Kelvin sign (U+212A) form a group of their own,
+	// even though Unicode case folding would put them with the plain "kelvin"s.
+	for _, name := range []string{"Kelvin", "kelvin", "\u212aelvin", "\u212aELVIN"} {
+		server.createItem(name)
+	}
+	response := server.get("/api/duplicates")
+	expectStatus(t, response, http.StatusOK)
+	var body struct {
+		Groups [][]struct {
+			Name string `json:"name"`
+		} `json:"groups"`
+	}
+	decodeResponse(t, response, &body)
+	got := [][]string{}
+	for _, group := range body.Groups {
+		names := []string{}
+		for _, item := range group {
+			names = append(names, item.Name)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"Kelvin", "kelvin"}, {"\u212aelvin", "\u212aELVIN"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("groups = %q, want %q", got, want)
+	}
+}
+
+func TestUnpreparedQuery(t *testing.T) {
+	server := newTestServer(t)
+	handler := NewHandler(server.db, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/test", nil))
+	err := handler.SqliteBeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.SqliteRollbackTransaction()
+	_, err = handler.SqliteQuery_ZeroRows(queryKey("Bogus"))
+	if err == nil || !strings.Contains(err.Error(), "Bogus") {
+		t.Fatalf("error = %v, want one naming Bogus", err)
+	}
+}
+
+func TestAcceptsToken(t *testing.T) {
+	for _, test := range []struct {
+		values []string
+		token  string
+		want   bool
+	}{
+		{nil, "gzip", false},
+		{[]string{"gzip"}, "gzip", true},
+		{[]string{"deflate, gzip;q=0.5"}, "gzip", true},
+		{[]string{"deflate", "gzip"}, "gzip", true},
+		{[]string{"gzip;q=0"}, "gzip", false},
+		{[]string{"gzip; q=0.0, *"}, "gzip", false},
+		{[]string{"*"}, "gzip", true},
+		{[]string{"deflate"}, "gzip", false},
+		{[]string{"text/csv"}, "text/csv", true},
+		{[]string{"application/json, text/csv;q=0"}, "text/csv", false},
+		{[]string{"application/json"}, "text/csv", false},
+	} {
+		if got := acceptsToken(test.values, test.token); got != test.want {
+			t.Errorf("acceptsToken(%q, %q) = %v, want %v", test.values, test.token, got, test.want)
+		}
+	}
+}
+
+func TestCreateStoreSectionNames(t *testing.T) {
+	server := newTestServer(t)
+
+	// Duplicates are what create-section would refuse: the same ignoring ASCII case, as SQLite's lower() folds.
+	expectError(t,
+		server.post("/api/create-store", `{"name":"Aldi","sections":["Dairy","DAIRY"]}`),
+		http.StatusBadRequest,
+		"bad_request")
+	server.mustPost("/api/create-store", `{"name":"Aldi","sections":["Énergie","énergie"]}`, http.StatusCreated, nil)
+}
+
+func TestEnumQueryParams(t *testing.T) {
+	server := newTestServer(t)
+	for _, test := range []struct {
+		method  string
+		path    string
+		allowed string // The allowed values listed in the 400 for an invalid value, or "" if valid
+	}{
+		{http.MethodGet, "/api/check-name?name=Milk&type=item", ""},
+		{http.MethodGet, "/api/check-name?name=Milk&type=store", ""},
+		{http.MethodGet, "/api/check-name?name=Milk&type=Item", "item, store"},
+		{http.MethodGet, "/api/items?has_note=true", ""},
+		{http.MethodGet, "/api/items?has_note=0", ""},
+		{http.MethodGet, "/api/items?has_note=maybe", "true, false"},
+		{http.MethodGet, "/api/items?include=store_count", ""},
+		{http.MethodGet, "/api/items?include=stores", "store_count"},
+		{http.MethodGet, "/api/stores?sort=recent", ""},
+		{http.MethodGet, "/api/stores?sort=banana", "recent"},
+		{http.MethodPost, "/api/import-text?on_list=true", ""},
+		{http.MethodPost, "/api/import-text?on_list=yes", "true, false"},
+	} {
+		response := server.do(httptest.NewRequest(test.method, test.path, strings.NewReader("Milk\n")))
+		if test.allowed == "" {
+			expectStatus(t, response, http.StatusOK)
+			continue
+		}
+		expectError(t, response, http.StatusBadRequest, "invalid_query_param")
+		if !strings.Contains(response.Body.String(), "(expected one of: "+test.allowed+")") {
+			t.Fatalf("%s %s: body %s doesn't list %s", test.method, test.path, response.Body, test.allowed)
+		}
+	}
+}
+
+// POST /api/import-text with 1000 new names.
+func BenchmarkImportText(b *testing.B) {
+	server := newTestServer(b)
+	var text strings.Builder
+	for i := 0; b.Loop(); i++ {
+		text.Reset()
+		for j := range 1000 {
+			fmt.Fprintf(&text, "Item %d-%d\n", i, j)
+		}
+		var body struct {
+			Created []json.RawMessage `json:"created"`
+		}
+		server.mustPost("/api/import-text", text.String(), http.StatusOK, &body)
+		if len(body.Created) != 1000 {
+			b.Fatalf("created %d items, want 1000", len(body.Created))
+		}
+	}
+}
+
+func TestGetItemsFields(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+	milk := server.createItem("Milk")
+	server.mustPost("/api/item-in-store", fmt.Sprintf(`{"item":%d,"store":%d}`, milk, store), http.StatusOK, nil)
+
+	fields := func(path string) []string {
+		t.Helper()
+		response := server.get(path)
+		expectStatus(t, response, http.StatusOK)
+		var body struct {
+			Items []map[string]json.RawMessage `json:"items"`
+		}
+		decodeResponse(t, response, &body)
+		if len(body.Items) != 1 {
+			t.Fatalf("GET %s: %d items, want 1", path, len(body.Items))
+		}
+		return slices.Sorted(maps.Keys(body.Items[0]))
+	}
+	for path, want := range map[string][]string{
+		"/api/items?fields=id,name":                                 {"id", "name"},
+		"/api/items?fields=id,name&include=store_count":             {"id", "name", "store_count"},
+		"/api/items?fields=id,store_count&include=store_count":      {"id", "store_count"},
+		"/api/items?fields=id,name,store_count&include=store_count": {"id", "name", "store_count"},
+	} {
+		if got := fields(path); !slices.Equal(got, want) {
+			t.Errorf("GET %s: fields %v, want %v", path, got, want)
+		}
+	}
+
+	// Without include, store_count isn't a field.
+	expectError(t, server.get("/api/items?fields=id,store_count"), http.StatusBadRequest, "invalid_query_param")
+}
+
+func TestReadMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	fsys := fstest.MapFS{"migrations/0000.sql": file, "migrations/0001.sql": file, "migrations/0002.sql": file}
+	migrations, latest, err := readMigrations(fsys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []migration{{name: "0001.sql", version: 1}, {name: "0002.sql", version: 2}}
+	if !slices.Equal(migrations, want) || latest != 2 {
+		t.Fatalf("readMigrations = %v, %d, want %v, 2", migrations, latest, want)
+	}
+
+	// With a gap, nothing runs.
+	delete(fsys, "migrations/0001.sql")
+	_, _, err = readMigrations(fsys, 0)
+	if err == nil || !strings.Contains(err.Error(), "migration 0001 is missing") {
+		t.Fatalf("readMigrations with a gap: error = %v, want 0001 missing", err)
+	}
+}
+
+func TestReadEndpointsNotModified(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+	server.createSection(store, "Dairy")
+	item := server.createItem("Milk")
+	version := server.dataVersion()
+
+	for _, path := range []string{
+		"/api/checksum",
+		"/api/duplicates",
+		fmt.Sprintf("/api/item/%d", item),
+		"/api/items",
+		fmt.Sprintf("/api/items/changed-since?t=%d", time.Now().Unix()),
+		"/api/items/recent",
+		"/api/items/unused",
+		"/api/layouts",
+		fmt.Sprintf("/api/list/coverage-gaps?store=%d", store),
+		"/api/list/export",
+		"/api/list/orphans",
+		"/api/list/store-coverage",
+		"/api/low-stock",
+		"/api/needed",
+		"/api/sections/by-name?name=Dairy",
+		"/api/sections/items?name=Dairy",
+		"/api/store-stats",
+		fmt.Sprintf("/api/store/%d/health", store),
+		"/api/stores",
+		"/api/sync-status",
+		"/api/templates",
+		fmt.Sprintf("/api/trip?store=%d", store),
+	} {
+		get := func(version int64) *httptest.ResponseRecorder {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			request.Header.Set("If-None-Match", fmt.Sprintf(`"%d"`, version))
+			return server.do(request)
+		}
+		if response := get(version); response.Code != http.StatusNotModified || response.Body.Len() != 0 {
+			t.Errorf("GET %s with the current version: status = %d, body %q; want 304, no body",
+				path, response.Code, response.Body)
+		}
+		if response := get(version - 1); response.Code != http.StatusOK {
+			t.Errorf("GET %s with an older version: status = %d, want 200; body: %s", path, response.Code, response.Body)
+		}
+	}
+}
+
+func TestSaveTemplate(t *testing.T) {
+	server := newTestServer(t)
+	server.mustPost("/api/import-text?on_list=true", "Tent\nMatches\n", http.StatusOK, nil)
+
+	var body struct {
+		Items int64 `json:"items"`
+	}
+	server.mustPost("/api/save-template", `{"name":"Camping trip"}`, http.StatusCreated, &body)
+	if body.Items != 2 {
+		t.Fatalf("items = %d, want 2", body.Items)
+	}
+	expectError(t, server.post("/api/save-template", `{"name":"Camping trip"}`), http.StatusConflict, "conflict")
+
+	name := strings.Repeat("x", shoppingMaxTemplateName)
+	server.mustPost("/api/save-template", `{"name":"`+name+`"}`, http.StatusCreated, nil)
+	expectError(t,
+		server.post("/api/save-template", `{"name":"`+name+`x"}`),
+		http.StatusUnprocessableEntity,
+		"name_too_long")
+}
+
+// The value at path in a JSON response body, e.g. "stores.0.sections".
+func jsonPath(t testing.TB, response *httptest.ResponseRecorder, path string) any {
+	t.Helper()
+	var value any
+	decodeResponse(t, response, &value)
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(v) {
+				t.Fatalf("no %s in %s", path, response.Body)
+			}
+			value = v[i]
+		default:
+			t.Fatalf("no %s in %s", path, response.Body)
+		}
+	}
+	return value
+}
+
+func TestImportRoundTripsNonAsciiCaseSections(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+	server.createSection(store, "Énergie")
+	server.createSection(store, "énergie")
+
+	response := server.get("/api/items")
+	expectStatus(t, response, http.StatusOK)
+	server.mustPost("/api/import", response.Body.String(), http.StatusOK, nil)
+}
+
+func TestEmptyListsAreEmptyArrays(t *testing.T) {
+	check := func(t *testing.T, server *testServer, path string, fields ...string) {
+		t.Helper()
+		response := server.get(path)
+		expectStatus(t, response, http.StatusOK)
+		for _, field := range fields {
+			if value, ok := jsonPath(t, response, field).([]any); !ok || len(value) != 0 {
+				t.Errorf("GET %s: %s = %#v, want []", path, field, value)
+			}
+		}
+	}
+
+	server := newTestServer(t)
+	check(t, server, "/api/autocomplete?q=a", "items")
+	check(t, server, "/api/duplicates", "groups")
+	check(t, server, "/api/items", "items", "stores", "sections", "item_stores")
+	check(t, server, "/api/items?since=0",
+		"items", "stores", "sections", "item_stores",
+		"deleted_items", "deleted_stores", "deleted_sections", "deleted_item_stores")
+	check(t, server, fmt.Sprintf("/api/items/changed-since?t=%d", time.Now().Unix()), "items")
+	check(t, server, "/api/items/recent", "items")
+	check(t, server, "/api/items/unused", "items")
+	check(t, server, "/api/layouts", "stores")
+	check(t, server, "/api/list/export", "items")
+	check(t, server, "/api/list/orphans", "items")
+	check(t, server, "/api/list/store-coverage", "items", "covering_stores", "uncovered")
+	check(t, server, "/api/low-stock", "items")
+	check(t, server, "/api/needed", "items")
+	check(t, server, "/api/sections/by-name?name=Dairy", "stores")
+	check(t, server, "/api/sections/items?name=Dairy", "groups")
+	check(t, server, "/api/store-stats", "stores")
+	check(t, server, "/api/stores", "stores")
+	check(t, server, "/api/templates", "templates")
+
+	// Lists nested in a store, or about one
+	store := server.createStore("Aldi")
+	check(t, server, "/api/layouts", "stores.0.sections")
+	check(t, server, fmt.Sprintf("/api/list/coverage-gaps?store=%d", store), "items")
+	check(t, server, fmt.Sprintf("/api/store/%d/health", store), "empty_sections")
+	check(t, server, "/api/stores", "stores.0.sections")
+	server.createSection(store, "Dairy")
+	check(t, server, "/api/sections/items?name=Dairy", "groups.0.items")
+}
+
+func TestCheckoutRecordsPurchases(t *testing.T) {
+	server := newTestServer(t)
+	store := server.createStore("Aldi")
+	var imported struct {
+		Created []struct {
+			Id int64 `json:"id"`
+		} `json:"created"`
+	}
+	server.mustPost("/api/import-text?on_list=true", "Milk, 2 l\nBread\n", http.StatusOK, &imported)
+	milk, bread := imported.Created[0].Id, imported.Created[1].Id
+
+	server.mustPost("/api/checkout", fmt.Sprintf(`{"store":%d,"items":[%d,%d]}`, store, milk, bread), http.StatusOK, nil)
+
+	rows, err := server.db.Query("SELECT item, store, quantity FROM purchases ORDER BY item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var purchases []string
+	for rows.Next() {
+		var item, store int64
+		var quantity *float64
+		err = rows.Scan(&item, &store, &quantity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quantity == nil {
+			purchases = append(purchases, fmt.Sprintf("%d at %d", item, store))
+		} else {
+			purchases = append(purchases, fmt.Sprintf("%d at %d, %g", item, store, *quantity))
+		}
+	}
+	want := []string{fmt.Sprintf("%d at %d, 2", milk, store), fmt.Sprintf("%d at %d", bread, store)}
+	if !slices.Equal(purchases, want) {
+		t.Fatalf("purchases = %q, want %q", purchases, want)
+	}
+}
+
+func TestRenameToSameName(t *testing.T) {
+	server := newTestServer(t)
+	milk := server.createItem("Milk")
+	server.createItem("Eggs")
+	aldi := server.createStore("Aldi")
+	server.createStore("Lidl")
+
+	itemName := func() string {
+		t.Helper()
+		response := server.get(fmt.Sprintf("/api/item/%d", milk))
+		expectStatus(t, response, http.StatusOK)
+		var body struct {
+			Item itemRow `json:"item"`
+		}
+		decodeResponse(t, response, &body)
+		return body.Item.Name
+	}
+	storeName := func() string {
+		t.Helper()
+		response := server.get("/api/stores")
+		expectStatus(t, response, http.StatusOK)
+		var body struct {
+			Stores []struct {
+				Id   int64  `json:"id"`
+				Name string `json:"name"`
+			} `json:"stores"`
+		}
+		decodeResponse(t, response, &body)
+		for _, store := range body.Stores {
+			if store.Id == aldi {
+				return store.Name
+			}
+		}
+		t.Fatalf("no store %d", aldi)
+		return ""
+	}
+
+	for _, name := range []string{"Milk", "MILK"} {
+		server.mustPost("/api/rename-item", fmt.Sprintf(`{"id":%d,"name":%s}`, milk, jsonString(name)), http.StatusOK, nil)
+		if got := itemName(); got != name {
+			t.Fatalf("item name = %q, want %q", got, name)
+		}
+	}
+	expectError(t, server.post("/api/rename-item", fmt.Sprintf(`{"id":%d,"name":"Eggs"}`, milk)), http.StatusConflict, "conflict")
+
+	for _, name := range []string{"Aldi", "ALDI"} {
+		server.mustPost("/api/rename-store", fmt.Sprintf(`{"id":%d,"name":%s}`, aldi, jsonString(name)), http.StatusOK, nil)
+		if got := storeName(); got != name {
+			t.Fatalf("store name = %q, want %q", got, name)
+		}
+	}
+	expectError(t, server.post("/api/rename-store", fmt.Sprintf(`{"id":%d,"name":"Lidl"}`, aldi)), http.StatusConflict, "conflict")
+}
+
+func TestCreateWithBogusIds(t *testing.T) {
+	server := newTestServer(t)
+	aldi := server.createStore("Aldi")
+	milk := server.createItem("Milk")
+
+	expectError(t, server.post("/api/create-item", `{"name":"Eggs","store":12345}`), http.StatusConflict, "conflict")
+	expectError(t,
+		server.post("/api/create-item", fmt.Sprintf(`{"name":"Eggs","store":%d,"section":12345}`, aldi)),
+		http.StatusConflict,
+		"conflict")
+	expectError(t, server.post("/api/create-store", `{"name":"Lidl","item":12345}`), http.StatusConflict, "conflict")
+
+	// Nothing was created, and real ids still work.
+	server.mustPost("/api/create-item", fmt.Sprintf(`{"name":"Eggs","store":%d}`, aldi), http.StatusCreated, nil)
+	server.mustPost("/api/create-store", fmt.Sprintf(`{"name":"Lidl","item":%d}`, milk), http.StatusCreated, nil)
+}
+
+// GET /api/items on a big catalog: 10,000 items, 5 stores with 20 sections each, and 20,000 item_stores rows. The
+// cached response is thrown away each time, so this measures building it.
+func BenchmarkGetItems(b *testing.B) {
+	server := newTestServer(b)
+	_, err := server.db.Exec(`
+		WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 10000)
+		INSERT INTO items (name, on_list) SELECT 'Item ' || i, i % 2 FROM n;
+
+		WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 5)
+		INSERT INTO stores (name) SELECT 'Store ' || i FROM n;
+
+		WITH RECURSIVE n (i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < 19)
+		INSERT INTO sections (store, position, name) SELECT stores.id, n.i, 'Section ' || n.i FROM stores, n;
+
+		INSERT INTO item_stores (item, store, sold, section, order_index)
+		SELECT items.id, stores.id, 1, (
+			SELECT sections.id FROM sections WHERE sections.store = stores.id AND sections.position = items.id % 20
+		), items.id
+		FROM items, stores
+		WHERE (items.id + 5 - stores.id) % 5 IN (0, 1);
+
+		UPDATE data_version SET version = version + 1;`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for b.Loop() {
+		clearCachedItemsDump()
+		expectStatus(b, server.get("/api/items"), http.StatusOK)
+	}
+}
+
+func TestDecodeJsonRequestBodyUnknownFields(t *testing.T) {
+	server := newTestServer(t)
+
+	// A valid body
+	server.createItem("Milk")
+
+	// Insist on a 400 naming the field, and that nothing was created.
+	expectUnknownField := func(body string, field string) {
+		t.Helper()
+		before := server.dataVersion()
+		response := server.post("/api/create-item", body)
+		expectError(t, response, http.StatusBadRequest, "unknown_field")
+		var errorBody struct {
+			Error struct {
+				Details struct {
+					Field string `json:"field"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		decodeResponse(t, response, &errorBody)
+		if errorBody.Error.Details.Field != field {
+			t.Fatalf("field = %q, want %q; body: %s", errorBody.Error.Details.Field, field, response.Body)
+		}
+		if after := server.dataVersion(); after != before {
+			t.Fatalf("data version went from %d to %d, want no change", before, after)
+		}
+	}
+
+	// An extra field
+	expectUnknownField(`{"name":"Eggs","colour":"brown"}`, "colour")
+
+	// A misspelled required field (not "empty_name", which would be confusing)
+	expectUnknownField(`{"nam":"Eggs"}`, "nam")
+}
@@ -0,0 +1,3 @@
+ALTER TABLE items
+ADD COLUMN low_stock INTEGER NOT NULL DEFAULT 0
+CHECK (low_stock IN (0, 1));
@@ -0,0 +1,7 @@
+-- Stores and sections from before this have no known creation or update time, so they're left at 0, meaning
+-- "unknown", rather than all looking like they were just changed.
+ALTER TABLE stores ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stores ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE sections ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sections ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,25 @@
+CREATE TABLE trips (
+  id INTEGER PRIMARY KEY,
+  store INTEGER REFERENCES stores (id) ON DELETE SET NULL,
+  started_at INTEGER NOT NULL,
+  ended_at INTEGER
+);
+
+-- Only one trip can be in progress at a time.
+CREATE UNIQUE INDEX trips_one_active ON trips ((ended_at IS NULL)) WHERE ended_at IS NULL;
+
+-- Items bought so far on a trip (that hasn't ended yet).
+CREATE TABLE trip_items (
+  trip INTEGER NOT NULL REFERENCES trips (id) ON DELETE CASCADE,
+  item INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+  PRIMARY KEY (trip, item)
+) WITHOUT ROWID;
+
+-- Purchase history. Deleting a store or trip keeps its purchases around.
+CREATE TABLE purchases (
+  id INTEGER PRIMARY KEY,
+  item INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+  store INTEGER REFERENCES stores (id) ON DELETE SET NULL,
+  trip INTEGER REFERENCES trips (id) ON DELETE SET NULL,
+  bought_at INTEGER NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE items ADD COLUMN quantity REAL CHECK (quantity > 0);
+ALTER TABLE items ADD COLUMN unit TEXT;
+ALTER TABLE items ADD COLUMN note TEXT;
@@ -0,0 +1,4 @@
+-- For case-insensitive prefix matching (LIKE 'foo%') on item names.
+CREATE INDEX items_name_nocase ON items (name COLLATE NOCASE);
+
+CREATE INDEX purchases_item ON purchases (item);
@@ -0,0 +1 @@
+ALTER TABLE item_stores ADD COLUMN order_index INTEGER NOT NULL DEFAULT 0;
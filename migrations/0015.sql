@@ -0,0 +1,3 @@
+-- Section names are unique within a store, ignoring case. (Existing duplicates are renamed before this runs; see
+-- renameDuplicateSectionNames).
+CREATE UNIQUE INDEX sections_store_name_unique ON sections (store, lower(name));
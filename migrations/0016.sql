@@ -0,0 +1 @@
+ALTER TABLE items ADD COLUMN have INTEGER NOT NULL DEFAULT 0 CHECK (have IN (0, 1));
@@ -0,0 +1,7 @@
+-- Items from before this have no known creation or update time, so they're left at 0, meaning "unknown", rather than
+-- all looking like they were just changed.
+ALTER TABLE items ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+
+-- For GET /api/items/recent.
+CREATE INDEX items_updated_at ON items (updated_at);
@@ -0,0 +1,2 @@
+ALTER TABLE stores ADD COLUMN tax_rate REAL CHECK (tax_rate BETWEEN 0 AND 1);
+ALTER TABLE stores ADD COLUMN loyalty_note TEXT;
@@ -0,0 +1,7 @@
+-- The highest data version ever handed out, so that the data version can be kept from going backwards.
+CREATE TABLE data_version_high_water (
+    version INTEGER NOT NULL
+);
+
+INSERT INTO data_version_high_water (version)
+SELECT version FROM data_version;
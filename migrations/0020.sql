@@ -0,0 +1,2 @@
+-- Display-only aisle label ("7", "12B"), separate from the section's position.
+ALTER TABLE sections ADD COLUMN aisle TEXT;
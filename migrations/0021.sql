@@ -0,0 +1,17 @@
+-- Saved shopping lists ("camping trip") that can be put back on the list in one go.
+CREATE TABLE templates (
+  id INTEGER PRIMARY KEY,
+  name TEXT UNIQUE NOT NULL,
+  created_at INTEGER NOT NULL
+);
+
+-- The items in a template. The item's name is kept too, so that a deleted item can be recreated when the template is
+-- applied.
+CREATE TABLE template_items (
+  template INTEGER NOT NULL REFERENCES templates (id) ON DELETE CASCADE,
+  item INTEGER REFERENCES items (id) ON DELETE SET NULL,
+  name TEXT NOT NULL,
+  PRIMARY KEY (template, name)
+) WITHOUT ROWID;
+
+CREATE INDEX template_items_item ON template_items (item);
@@ -0,0 +1,2 @@
+-- What an item costs at a store, in cents (or whatever the smallest unit of the currency is).
+ALTER TABLE item_stores ADD COLUMN price_cents INTEGER CHECK (price_cents >= 0);
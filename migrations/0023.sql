@@ -0,0 +1,2 @@
+-- How much was bought, if known.
+ALTER TABLE purchases ADD COLUMN quantity REAL CHECK (quantity > 0);
@@ -0,0 +1,104 @@
+-- Per-row version stamps, for incremental sync (GET /api/items?since=N). A row's version is the data version under
+-- which its latest change was published. Every mutation bumps the data version after making its changes, so while a
+-- change is being made, that's one past the current data version. The triggers below keep the stamps up to date, so
+-- that no handler can forget to.
+ALTER TABLE items ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stores ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sections ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE item_stores ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+
+-- Tombstones for deleted rows, stamped the same way. Recreating a row removes its tombstone.
+CREATE TABLE deleted_items (
+  id INTEGER PRIMARY KEY,
+  version INTEGER NOT NULL
+);
+
+CREATE TABLE deleted_stores (
+  id INTEGER PRIMARY KEY,
+  version INTEGER NOT NULL
+);
+
+CREATE TABLE deleted_sections (
+  id INTEGER PRIMARY KEY,
+  version INTEGER NOT NULL
+);
+
+CREATE TABLE deleted_item_stores (
+  item INTEGER NOT NULL,
+  store INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  PRIMARY KEY (item, store)
+) WITHOUT ROWID;
+
+-- The oldest data version a client can sync incrementally from. Nothing before this migration was stamped, and when
+-- the data version is fast-forwarded past an older copy of the data, the stamps can't be trusted either.
+CREATE TABLE sync_floor (
+  version INTEGER NOT NULL
+);
+
+INSERT INTO sync_floor (version)
+SELECT version FROM data_version;
+
+CREATE TRIGGER items_inserted AFTER INSERT ON items
+BEGIN
+  UPDATE items SET version = (SELECT version + 1 FROM data_version) WHERE id = NEW.id;
+  DELETE FROM deleted_items WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER items_updated AFTER UPDATE ON items
+BEGIN
+  UPDATE items SET version = (SELECT version + 1 FROM data_version) WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER items_deleted AFTER DELETE ON items
+BEGIN
+  INSERT OR REPLACE INTO deleted_items (id, version) SELECT OLD.id, version + 1 FROM data_version;
+END;
+
+CREATE TRIGGER stores_inserted AFTER INSERT ON stores
+BEGIN
+  UPDATE stores SET version = (SELECT version + 1 FROM data_version) WHERE id = NEW.id;
+  DELETE FROM deleted_stores WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER stores_updated AFTER UPDATE ON stores
+BEGIN
+  UPDATE stores SET version = (SELECT version + 1 FROM data_version) WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER stores_deleted AFTER DELETE ON stores
+BEGIN
+  INSERT OR REPLACE INTO deleted_stores (id, version) SELECT OLD.id, version + 1 FROM data_version;
+END;
+
+CREATE TRIGGER sections_inserted AFTER INSERT ON sections
+BEGIN
+  UPDATE sections SET version = (SELECT version + 1 FROM data_version) WHERE id = NEW.id;
+  DELETE FROM deleted_sections WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER sections_updated AFTER UPDATE ON sections
+BEGIN
+  UPDATE sections SET version = (SELECT version + 1 FROM data_version) WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER sections_deleted AFTER DELETE ON sections
+BEGIN
+  INSERT OR REPLACE INTO deleted_sections (id, version) SELECT OLD.id, version + 1 FROM data_version;
+END;
+
+CREATE TRIGGER item_stores_inserted AFTER INSERT ON item_stores
+BEGIN
+  UPDATE item_stores SET version = (SELECT version + 1 FROM data_version) WHERE item = NEW.item AND store = NEW.store;
+  DELETE FROM deleted_item_stores WHERE item = NEW.item AND store = NEW.store;
+END;
+
+CREATE TRIGGER item_stores_updated AFTER UPDATE ON item_stores
+BEGIN
+  UPDATE item_stores SET version = (SELECT version + 1 FROM data_version) WHERE item = NEW.item AND store = NEW.store;
+END;
+
+CREATE TRIGGER item_stores_deleted AFTER DELETE ON item_stores
+BEGIN
+  INSERT OR REPLACE INTO deleted_item_stores (item, store, version) SELECT OLD.item, OLD.store, version + 1 FROM data_version;
+END;
@@ -0,0 +1,14 @@
+-- A client can't sync incrementally from below the sync floor, so tombstones at or below it will never be read again.
+-- Drop them whenever the floor is raised, and drop any already left behind by earlier raises.
+CREATE TRIGGER sync_floor_raised AFTER UPDATE ON sync_floor
+BEGIN
+  DELETE FROM deleted_items WHERE version <= NEW.version;
+  DELETE FROM deleted_stores WHERE version <= NEW.version;
+  DELETE FROM deleted_sections WHERE version <= NEW.version;
+  DELETE FROM deleted_item_stores WHERE version <= NEW.version;
+END;
+
+DELETE FROM deleted_items WHERE version <= (SELECT version FROM sync_floor);
+DELETE FROM deleted_stores WHERE version <= (SELECT version FROM sync_floor);
+DELETE FROM deleted_sections WHERE version <= (SELECT version FROM sync_floor);
+DELETE FROM deleted_item_stores WHERE version <= (SELECT version FROM sync_floor);